@@ -5,6 +5,7 @@ import android.content.Context
 object Prefs {
     private const val FILE = "raikiri_prefs"
     private const val KEY_SERVER_URL = "server_url"
+    private const val KEY_AUTH_TOKEN = "auth_token"
 
     fun getServerUrl(context: Context): String =
         context.getSharedPreferences(FILE, Context.MODE_PRIVATE)
@@ -14,4 +15,13 @@ object Prefs {
         context.getSharedPreferences(FILE, Context.MODE_PRIVATE)
             .edit().putString(KEY_SERVER_URL, url).apply()
     }
+
+    fun getAuthToken(context: Context): String =
+        context.getSharedPreferences(FILE, Context.MODE_PRIVATE)
+            .getString(KEY_AUTH_TOKEN, "") ?: ""
+
+    fun setAuthToken(context: Context, token: String) {
+        context.getSharedPreferences(FILE, Context.MODE_PRIVATE)
+            .edit().putString(KEY_AUTH_TOKEN, token).apply()
+    }
 }
@@ -1,6 +1,8 @@
 package com.tanq16.raikiri
 
 import android.app.Application
+import com.tanq16.raikiri.data.api.CreateTokenRequest
+import com.tanq16.raikiri.data.api.LoginRequest
 import com.tanq16.raikiri.data.api.RaikiriApi
 import com.tanq16.raikiri.data.repository.MusicRepository
 import com.tanq16.raikiri.playback.PlaybackConnection
@@ -21,6 +23,7 @@ class RaikiriApp : Application() {
     override fun onCreate() {
         super.onCreate()
         playbackConnection = PlaybackConnection(this)
+        MusicRepository.authToken = Prefs.getAuthToken(this)
         val serverUrl = Prefs.getServerUrl(this)
         if (serverUrl.isNotBlank()) {
             repository = MusicRepository(getOrCreateApi(serverUrl), serverUrl)
@@ -35,6 +38,17 @@ class RaikiriApp : Application() {
         val okhttp = OkHttpClient.Builder()
             .connectTimeout(10, TimeUnit.SECONDS)
             .readTimeout(30, TimeUnit.SECONDS)
+            .addInterceptor { chain ->
+                val token = Prefs.getAuthToken(this)
+                val request = if (token.isNotBlank() && chain.request().header("Authorization") == null) {
+                    chain.request().newBuilder()
+                        .header("Authorization", "Bearer $token")
+                        .build()
+                } else {
+                    chain.request()
+                }
+                chain.proceed(request)
+            }
             .build()
 
         val json = Json { ignoreUnknownKeys = true }
@@ -56,4 +70,21 @@ class RaikiriApp : Application() {
         val newApi = if (url.isNotBlank()) getOrCreateApi(url) else null
         repository = MusicRepository(newApi, url)
     }
+
+    // Exchanges the credentials for a long-lived API token scoped to browsing and
+    // streaming, so the app keeps working across server restarts.
+    suspend fun login(url: String, username: String, password: String): Result<Unit> = runCatching {
+        val api = getOrCreateApi(url)
+        val session = api.login(LoginRequest(username, password))
+        val created = api.createToken(
+            "Bearer ${session.token}",
+            CreateTokenRequest(name = "android", scopes = listOf("browse", "stream"))
+        )
+        updateAuthToken(created.token)
+    }
+
+    fun updateAuthToken(token: String) {
+        Prefs.setAuthToken(this, token)
+        MusicRepository.authToken = token
+    }
 }
@@ -11,3 +11,70 @@ data class FileEntry(
     val thumb: String = "",
     val modified: String = ""
 )
+
+@Serializable
+data class SearchResponse(
+    val query: String = "",
+    val total: Int = 0,
+    val offset: Int = 0,
+    val limit: Int = 0,
+    val results: List<FileEntry> = emptyList()
+)
+
+@Serializable
+data class LoginRequest(
+    val username: String,
+    val password: String
+)
+
+@Serializable
+data class LoginResponse(
+    val user: String,
+    val token: String
+)
+
+@Serializable
+data class CreateTokenRequest(
+    val name: String,
+    val scopes: List<String>
+)
+
+@Serializable
+data class CreateTokenResponse(
+    val id: String,
+    val token: String
+)
+
+// Codecs and containers the device plays natively, posted to api/stream so
+// the server can direct-play or remux instead of transcoding.
+@Serializable
+data class DeviceProfile(
+    val name: String,
+    val containers: List<String>,
+    val videoCodecs: List<String>,
+    val audioCodecs: List<String>,
+    val maxAudioChannels: Int = 2,
+    val hdr: Boolean = false
+)
+
+@Serializable
+data class StreamRendition(
+    val width: Int = 0,
+    val height: Int = 0,
+    val bitrate: Int = 0,
+    val videoCodec: String = "",
+    val transcoded: Boolean = false
+)
+
+@Serializable
+data class StreamResponse(
+    val mode: String,
+    val source: String,
+    val url: String,
+    val sessionId: String = "",
+    val duration: Double = 0.0,
+    val start: Double = 0.0,
+    val startOffset: Double = 0.0,
+    val availableSources: List<String> = emptyList(),
+    val rendition: StreamRendition? = null
+)
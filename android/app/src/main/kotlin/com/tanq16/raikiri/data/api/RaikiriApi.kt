@@ -1,6 +1,9 @@
 package com.tanq16.raikiri.data.api
 
+import retrofit2.http.Body
 import retrofit2.http.GET
+import retrofit2.http.Header
+import retrofit2.http.POST
 import retrofit2.http.Query
 
 interface RaikiriApi {
@@ -10,4 +13,35 @@ interface RaikiriApi {
         @Query("mode") mode: String = "music",
         @Query("recursive") recursive: Boolean = false
     ): List<FileEntry>
+
+    @GET("api/search")
+    suspend fun search(
+        @Query("q") query: String,
+        @Query("mode") mode: String = "music",
+        @Query("type") type: String? = null,
+        @Query("limit") limit: Int = 200,
+        @Query("offset") offset: Int = 0
+    ): SearchResponse
+
+    // Starts playback of a video, letting the server pick direct play, remux
+    // or transcode from what the device reports it can decode.
+    @POST("api/stream")
+    suspend fun startStream(
+        @Query("file") file: String,
+        @Query("mode") mode: String = "files",
+        @Body profile: DeviceProfile,
+        @Query("source") source: String? = null,
+        @Query("start") start: Double? = null,
+        @Query("maxHeight") maxHeight: Int? = null,
+        @Query("maxBitrate") maxBitrate: Int? = null
+    ): StreamResponse
+
+    @POST("api/login")
+    suspend fun login(@Body body: LoginRequest): LoginResponse
+
+    @POST("api/tokens")
+    suspend fun createToken(
+        @Header("Authorization") session: String,
+        @Body body: CreateTokenRequest
+    ): CreateTokenResponse
 }
@@ -27,6 +27,12 @@ class MusicRepository(
         songs
     }
 
+    // Ranked server-side search, so results don't need the whole library.
+    suspend fun searchSongs(query: String): Result<List<FileEntry>> = runCatching {
+        val api = api ?: throw IllegalStateException("Server not configured")
+        api.search(query = query, mode = "music", type = "audio").results
+    }
+
     fun clearCache() {
         allSongs = null
     }
@@ -39,11 +45,18 @@ class MusicRepository(
     }
 
     companion object {
+        // Players and image loaders fetch content URLs directly, so the
+        // credential travels as a query parameter instead of a header.
+        @Volatile
+        var authToken: String = ""
+
         fun contentUrl(serverUrl: String, path: String): String {
             val encoded = path.split("/").joinToString("/") {
                 Uri.encode(it)
             }
-            return "${serverUrl.trimEnd('/')}/content/$encoded?mode=music"
+            val token = authToken
+            val auth = if (token.isNotBlank()) "&token=${Uri.encode(token)}" else ""
+            return "${serverUrl.trimEnd('/')}/content/$encoded?mode=music$auth"
         }
 
         fun thumbUrl(serverUrl: String, thumbPath: String): String {
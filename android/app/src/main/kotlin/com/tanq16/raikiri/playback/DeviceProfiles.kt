@@ -0,0 +1,48 @@
+package com.tanq16.raikiri.playback
+
+import android.content.Context
+import android.hardware.display.DisplayManager
+import android.media.MediaCodecList
+import android.view.Display
+import com.tanq16.raikiri.data.api.DeviceProfile
+
+object DeviceProfiles {
+    // Decoder MIME types mapped to the codec names the server uses.
+    private val videoTypes = mapOf(
+        "video/avc" to "h264",
+        "video/hevc" to "hevc",
+        "video/x-vnd.on2.vp9" to "vp9",
+        "video/av01" to "av1"
+    )
+    private val audioTypes = mapOf(
+        "audio/mp4a-latm" to "aac",
+        "audio/mpeg" to "mp3",
+        "audio/opus" to "opus",
+        "audio/flac" to "flac",
+        "audio/ac3" to "ac3",
+        "audio/eac3" to "eac3"
+    )
+
+    // ExoPlayer extracts all of these containers itself.
+    private val containers = listOf("mp4", "mov", "mkv", "webm", "ts")
+
+    fun detect(context: Context): DeviceProfile {
+        val decoders = MediaCodecList(MediaCodecList.REGULAR_CODECS).codecInfos
+            .filter { !it.isEncoder }
+            .flatMap { it.supportedTypes.asList() }
+            .map { it.lowercase() }
+            .toSet()
+        val display = context.getSystemService(DisplayManager::class.java)
+            ?.getDisplay(Display.DEFAULT_DISPLAY)
+        val hdr = display?.hdrCapabilities?.supportedHdrTypes?.isNotEmpty() == true
+        return DeviceProfile(
+            name = "android",
+            containers = containers,
+            videoCodecs = videoTypes.filterKeys { it in decoders }.values.toList(),
+            audioCodecs = audioTypes.filterKeys { it in decoders }.values.toList(),
+            // ExoPlayer downmixes multichannel audio it can decode.
+            maxAudioChannels = 8,
+            hdr = hdr
+        )
+    }
+}
@@ -5,6 +5,8 @@ import androidx.lifecycle.ViewModelProvider
 import androidx.lifecycle.viewModelScope
 import com.tanq16.raikiri.data.api.FileEntry
 import com.tanq16.raikiri.data.repository.MusicRepository
+import kotlinx.coroutines.Job
+import kotlinx.coroutines.delay
 import kotlinx.coroutines.flow.MutableStateFlow
 import kotlinx.coroutines.flow.StateFlow
 import kotlinx.coroutines.flow.asStateFlow
@@ -27,6 +29,10 @@ class MusicViewModel(initialRepository: MusicRepository) : ViewModel() {
     private val _folderState = MutableStateFlow<UiState>(UiState.Loading)
     val folderState: StateFlow<UiState> = _folderState.asStateFlow()
 
+    private val _searchState = MutableStateFlow<UiState>(UiState.Success(emptyList()))
+    val searchState: StateFlow<UiState> = _searchState.asStateFlow()
+    private var searchJob: Job? = null
+
     val serverUrl: String get() = repository.serverUrl
 
     fun updateRepository(newRepo: MusicRepository) {
@@ -52,6 +58,17 @@ class MusicViewModel(initialRepository: MusicRepository) : ViewModel() {
         }
     }
 
+    fun search(query: String) {
+        searchJob?.cancel()
+        searchJob = viewModelScope.launch {
+            delay(250)
+            _searchState.value = UiState.Loading
+            repository.searchSongs(query)
+                .onSuccess { _searchState.value = UiState.Success(it) }
+                .onFailure { _searchState.value = UiState.Error(it.message ?: "Search failed") }
+        }
+    }
+
     fun refresh() {
         repository.clearCache()
         loadAllSongs()
@@ -17,19 +17,26 @@ import androidx.compose.runtime.Composable
 import androidx.compose.runtime.getValue
 import androidx.compose.runtime.mutableStateOf
 import androidx.compose.runtime.remember
+import androidx.compose.runtime.rememberCoroutineScope
 import androidx.compose.runtime.setValue
 import androidx.compose.ui.Modifier
 import androidx.compose.ui.platform.LocalContext
+import androidx.compose.ui.text.input.PasswordVisualTransformation
 import androidx.compose.ui.unit.dp
 import com.tanq16.raikiri.Prefs
 import com.tanq16.raikiri.RaikiriApp
 import com.tanq16.raikiri.ui.MusicViewModel
+import kotlinx.coroutines.launch
 
 @Composable
 fun SettingsScreen(musicVm: MusicViewModel) {
     val context = LocalContext.current
     var serverUrl by remember { mutableStateOf(Prefs.getServerUrl(context)) }
+    var username by remember { mutableStateOf("") }
+    var password by remember { mutableStateOf("") }
     var saved by remember { mutableStateOf(false) }
+    var error by remember { mutableStateOf<String?>(null) }
+    val scope = rememberCoroutineScope()
 
     Column(
         Modifier
@@ -71,12 +78,70 @@ fun SettingsScreen(musicVm: MusicViewModel) {
 
         Spacer(Modifier.height(16.dp))
 
+        Text(
+            text = "Account",
+            style = MaterialTheme.typography.titleMedium,
+            color = MaterialTheme.colorScheme.onBackground
+        )
+
+        Spacer(Modifier.height(8.dp))
+
+        OutlinedTextField(
+            value = username,
+            onValueChange = {
+                username = it
+                saved = false
+            },
+            modifier = Modifier.fillMaxWidth(),
+            placeholder = { Text("Username (leave empty if not required)") },
+            singleLine = true,
+            colors = OutlinedTextFieldDefaults.colors(
+                focusedBorderColor = MaterialTheme.colorScheme.primary,
+                unfocusedBorderColor = MaterialTheme.colorScheme.outline,
+                focusedContainerColor = MaterialTheme.colorScheme.surfaceVariant,
+                unfocusedContainerColor = MaterialTheme.colorScheme.surfaceVariant,
+            )
+        )
+
+        Spacer(Modifier.height(8.dp))
+
+        OutlinedTextField(
+            value = password,
+            onValueChange = {
+                password = it
+                saved = false
+            },
+            modifier = Modifier.fillMaxWidth(),
+            placeholder = { Text("Password") },
+            singleLine = true,
+            visualTransformation = PasswordVisualTransformation(),
+            colors = OutlinedTextFieldDefaults.colors(
+                focusedBorderColor = MaterialTheme.colorScheme.primary,
+                unfocusedBorderColor = MaterialTheme.colorScheme.outline,
+                focusedContainerColor = MaterialTheme.colorScheme.surfaceVariant,
+                unfocusedContainerColor = MaterialTheme.colorScheme.surfaceVariant,
+            )
+        )
+
+        Spacer(Modifier.height(16.dp))
+
         Button(
             onClick = {
                 val app = context.applicationContext as RaikiriApp
-                app.updateServerUrl(serverUrl.trimEnd('/'))
-                musicVm.updateRepository(app.repository)
-                saved = true
+                val url = serverUrl.trimEnd('/')
+                scope.launch {
+                    error = null
+                    if (username.isNotBlank()) {
+                        app.login(url, username, password).onFailure {
+                            error = "Login failed: ${it.message}"
+                            return@launch
+                        }
+                        password = ""
+                    }
+                    app.updateServerUrl(url)
+                    musicVm.updateRepository(app.repository)
+                    saved = true
+                }
             },
             modifier = Modifier.fillMaxWidth(),
             colors = ButtonDefaults.buttonColors(
@@ -87,6 +152,15 @@ fun SettingsScreen(musicVm: MusicViewModel) {
             Text(if (saved) "Saved" else "Save & Connect")
         }
 
+        error?.let {
+            Spacer(Modifier.height(8.dp))
+            Text(
+                text = it,
+                style = MaterialTheme.typography.bodyMedium,
+                color = MaterialTheme.colorScheme.error
+            )
+        }
+
         Spacer(Modifier.height(24.dp))
 
         OutlinedButton(
@@ -34,26 +34,24 @@ fun SongsScreen(
     playerVm: PlayerViewModel,
     serverUrl: String
 ) {
-    val uiState by musicVm.allSongsState.collectAsStateWithLifecycle()
+    val allSongsState by musicVm.allSongsState.collectAsStateWithLifecycle()
+    val searchState by musicVm.searchState.collectAsStateWithLifecycle()
     val currentTrack by playerVm.currentTrack.collectAsStateWithLifecycle()
     var query by rememberSaveable { mutableStateOf("") }
-    val visibleTracks = (uiState as? MusicViewModel.UiState.Success)?.let { state ->
-        if (query.length >= 2) {
-            val q = query.lowercase()
-            state.items.filter {
-                it.name.lowercase().contains(q) || it.path.lowercase().contains(q)
-            }
-        } else {
-            state.items
-        }
-    } ?: emptyList()
+    val searching = query.length >= 2
+    val uiState = if (searching) searchState else allSongsState
+    val visibleTracks = (uiState as? MusicViewModel.UiState.Success)?.items ?: emptyList()
 
     LaunchedEffect(Unit) {
-        if (uiState is MusicViewModel.UiState.Loading) {
+        if (allSongsState is MusicViewModel.UiState.Loading) {
             musicVm.loadAllSongs()
         }
     }
 
+    LaunchedEffect(query) {
+        if (searching) musicVm.search(query)
+    }
+
     Column(Modifier.fillMaxSize()) {
         Row(
             modifier = Modifier
@@ -82,7 +80,7 @@ fun SongsScreen(
 
             is MusicViewModel.UiState.Error -> {
                 Box(Modifier.fillMaxSize(), contentAlignment = Alignment.Center) {
-                    TextButton(onClick = { musicVm.loadAllSongs() }) {
+                    TextButton(onClick = { if (searching) musicVm.search(query) else musicVm.loadAllSongs() }) {
                         Text("Failed to load. Tap to retry.", color = MaterialTheme.colorScheme.error)
                     }
                 }
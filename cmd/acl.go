@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/tanq16/raikiri/internal/auth"
+	u "github.com/tanq16/raikiri/utils"
+)
+
+var aclFlags struct {
+	data    string
+	subject string
+	library string
+	path    string
+	allow   []string
+}
+
+var aclCmd = &cobra.Command{
+	Use:   "acl",
+	Short: "Manage per-library access control rules",
+	Long: `Manage per-library access control rules.
+
+Without rules every user can access everything. Once a rule exists, access is
+denied by default and the rule with the longest matching path prefix decides;
+on ties user rules beat group rules, which beat "*".
+
+Subjects are user:<name>, group:<name> or *. Libraries are media, music or *.
+Permissions are read and upload; a rule without permissions denies.`,
+}
+
+func openACL() *auth.ACL {
+	acl, err := auth.OpenACL(aclFlags.data)
+	if err != nil {
+		u.PrintFatal("failed to open access control list", err)
+	}
+	return acl
+}
+
+var aclAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Add an access rule",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		var allow []string
+		for _, v := range aclFlags.allow {
+			for p := range strings.SplitSeq(v, ",") {
+				if p = strings.TrimSpace(p); p != "" {
+					allow = append(allow, p)
+				}
+			}
+		}
+		rule := auth.Rule{
+			Subject: aclFlags.subject,
+			Library: aclFlags.library,
+			Path:    aclFlags.path,
+			Allow:   allow,
+		}
+		if err := openACL().AddRule(rule); err != nil {
+			u.PrintFatal("failed to add rule", err)
+		}
+		u.PrintSuccess("rule added")
+	},
+}
+
+var aclListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List access rules and groups",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		acl := openACL()
+		var rows [][]string
+		for i, r := range acl.Rules() {
+			allow := strings.Join(r.Allow, ",")
+			if allow == "" {
+				allow = "(deny)"
+			}
+			rows = append(rows, []string{strconv.Itoa(i), r.Subject, r.Library, "/" + r.Path, allow})
+		}
+		if len(rows) == 0 {
+			u.PrintInfo("no rules configured, all users can access everything")
+		} else {
+			u.PrintTable([]string{"#", "Subject", "Library", "Path", "Allow"}, rows)
+		}
+
+		groups := acl.Groups()
+		if len(groups) == 0 {
+			return
+		}
+		names := make([]string, 0, len(groups))
+		for g := range groups {
+			names = append(names, g)
+		}
+		sort.Strings(names)
+		rows = nil
+		for _, g := range names {
+			rows = append(rows, []string{g, strings.Join(groups[g], ", ")})
+		}
+		u.PrintGeneric("")
+		u.PrintTable([]string{"Group", "Members"}, rows)
+	},
+}
+
+var aclRemoveCmd = &cobra.Command{
+	Use:   "remove <index>",
+	Short: "Remove an access rule by its index from 'acl list'",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		index, err := strconv.Atoi(args[0])
+		if err != nil {
+			u.PrintFatal("index must be a number", err)
+		}
+		if err := openACL().RemoveRule(index); err != nil {
+			u.PrintFatal("failed to remove rule", err)
+		}
+		u.PrintSuccess(fmt.Sprintf("removed rule %d", index))
+	},
+}
+
+var aclGroupCmd = &cobra.Command{
+	Use:   "group <name> [users...]",
+	Short: "Set the members of a group (no users deletes the group)",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := openACL().SetGroup(args[0], args[1:]); err != nil {
+			u.PrintFatal("failed to update group", err)
+		}
+		if len(args) == 1 {
+			u.PrintSuccess(fmt.Sprintf("deleted group %s", args[0]))
+			return
+		}
+		u.PrintSuccess(fmt.Sprintf("group %s: %s", args[0], strings.Join(args[1:], ", ")))
+	},
+}
+
+func init() {
+	aclCmd.PersistentFlags().StringVarP(&aclFlags.data, "data", "d", ".raikiri", "Path to data directory (same as 'serve --data')")
+	aclAddCmd.Flags().StringVarP(&aclFlags.subject, "subject", "s", "*", "Who the rule applies to: user:<name>, group:<name> or *")
+	aclAddCmd.Flags().StringVarP(&aclFlags.library, "library", "l", "*", "Library: media, music or *")
+	aclAddCmd.Flags().StringVarP(&aclFlags.path, "path", "p", "", "Path prefix inside the library (empty for the whole library)")
+	aclAddCmd.Flags().StringSliceVarP(&aclFlags.allow, "allow", "a", nil, "Permissions to grant: read, upload (omit to deny)")
+	aclCmd.AddCommand(aclAddCmd, aclListCmd, aclRemoveCmd, aclGroupCmd)
+
+	rootCmd.AddCommand(aclCmd)
+}
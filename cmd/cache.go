@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/tanq16/raikiri/internal/cache"
+	"github.com/tanq16/raikiri/internal/media"
+	u "github.com/tanq16/raikiri/utils"
+)
+
+var cacheFlags struct {
+	cache   string
+	maxSize string
+	maxAge  time.Duration
+}
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Inspect and clean the HLS cache",
+	Long: `Inspect and clean the HLS cache.
+
+The cache holds shared streams (t_*), kept for replays and evicted by
+'raikiri serve' according to --cache-max-size and --cache-max-age, and
+per-viewer sessions (s_*). Purging streams the server is playing breaks that
+playback, so stop it first for a full purge.`,
+}
+
+var cacheStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show the streams in the cache",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		entries, err := cache.Scan(cacheFlags.cache)
+		if err != nil {
+			u.PrintFatal("failed to read cache directory", err)
+		}
+		if len(entries) == 0 {
+			u.PrintInfo("cache is empty")
+			return
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].LastUsed.After(entries[j].LastUsed) })
+		var total int64
+		var rows [][]string
+		for _, e := range entries {
+			total += e.Size
+			kind, file := "session", "-"
+			if e.Shared {
+				kind = "shared"
+			}
+			if e.Info.File != "" {
+				file = fmt.Sprintf("%s (%s)", filepath.Base(e.Info.File), e.Info.Source)
+			}
+			rows = append(rows, []string{e.Name, kind, file, media.FormatFileSize(e.Size), media.FormatModTime(e.LastUsed)})
+		}
+		u.PrintTable([]string{"Stream", "Kind", "File", "Size", "Last Used"}, rows)
+		u.PrintInfo(fmt.Sprintf("%d streams using %s", len(entries), media.FormatFileSize(total)))
+	},
+}
+
+var cachePurgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Remove streams from the cache",
+	Long: `Remove streams from the cache: with --max-size or --max-age, the ones the
+server's eviction policy would pick; otherwise all of them.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		maxSize, err := cache.ParseSize(cacheFlags.maxSize)
+		if err != nil {
+			u.PrintFatal("invalid --max-size", err)
+		}
+		entries, err := cache.Scan(cacheFlags.cache)
+		if err != nil {
+			u.PrintFatal("failed to read cache directory", err)
+		}
+		evict := entries
+		if maxSize > 0 || cacheFlags.maxAge > 0 {
+			evict = cache.Policy{MaxSize: maxSize, MaxAge: cacheFlags.maxAge}.Select(entries, time.Now(), nil)
+		}
+		var freed int64
+		removed := 0
+		for _, e := range evict {
+			if err := cache.Remove(cacheFlags.cache, e.Name); err != nil {
+				u.PrintError(fmt.Sprintf("failed to remove %s", e.Name), err)
+				continue
+			}
+			removed++
+			freed += e.Size
+		}
+		u.PrintSuccess(fmt.Sprintf("removed %d streams, freed %s", removed, media.FormatFileSize(freed)))
+	},
+}
+
+func init() {
+	cacheCmd.PersistentFlags().StringVarP(&cacheFlags.cache, "cache", "c", "/tmp", "Path to cache directory (same as 'serve --cache')")
+	cachePurgeCmd.Flags().StringVar(&cacheFlags.maxSize, "max-size", "0", "Only evict least recently used streams until the cache fits this size")
+	cachePurgeCmd.Flags().DurationVar(&cacheFlags.maxAge, "max-age", 0, "Only evict streams unused for this long")
+	cacheCmd.AddCommand(cacheStatsCmd, cachePurgeCmd)
+
+	rootCmd.AddCommand(cacheCmd)
+}
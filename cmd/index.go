@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/tanq16/raikiri/internal/index"
+	"github.com/tanq16/raikiri/internal/media"
+	u "github.com/tanq16/raikiri/utils"
+)
+
+var indexFlags struct {
+	data  string
+	media string
+	music string
+	probe bool
+}
+
+var indexCmd = &cobra.Command{
+	Use:   "index",
+	Short: "Inspect and rebuild the library index",
+	Long: `Inspect and rebuild the library index.
+
+'raikiri serve' keeps an index of both libraries in index.db in the data
+directory, updated from filesystem events and periodic rescans. The index is
+locked while the server runs, so stop it before using these commands.`,
+}
+
+func openIndex() *index.Index {
+	idx, err := index.Open(indexFlags.data)
+	if errors.Is(err, index.ErrLocked) {
+		u.PrintFatal("library index is in use, stop 'raikiri serve' first", err)
+	}
+	if err != nil {
+		u.PrintFatal("failed to open library index", err)
+	}
+	return idx
+}
+
+var indexRebuildCmd = &cobra.Command{
+	Use:   "rebuild",
+	Short: "Discard the index and scan both libraries from scratch",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		idx := openIndex()
+		defer idx.Close()
+
+		_, err := exec.LookPath("ffprobe")
+		probe := indexFlags.probe && err == nil
+		if indexFlags.probe && !probe {
+			u.PrintWarn("ffprobe not found in PATH, skipping media probing", nil)
+		}
+
+		for _, lib := range []index.Library{{Name: "media", Root: indexFlags.media}, {Name: "music", Root: indexFlags.music}} {
+			lib.Root, _ = filepath.Abs(filepath.Clean(lib.Root))
+			start := time.Now()
+			result, err := idx.Rebuild(lib.Name, lib.Root)
+			if err != nil {
+				u.PrintError(fmt.Sprintf("failed to index %s library at %s", lib.Name, lib.Root), err)
+				continue
+			}
+			u.PrintSuccess(fmt.Sprintf("indexed %s library: %d entries in %s", lib.Name, result.Entries, time.Since(start).Round(time.Millisecond)))
+			if probe {
+				u.PrintInfo(fmt.Sprintf("probing %s library", lib.Name))
+				n := idx.ProbePending(context.Background(), lib.Name, lib.Root)
+				u.PrintSuccess(fmt.Sprintf("probed %d files", n))
+			}
+		}
+	},
+}
+
+var indexStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show what the index holds for each library",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		idx := openIndex()
+		defer idx.Close()
+		stats, err := idx.Stats()
+		if err != nil {
+			u.PrintFatal("failed to read index", err)
+		}
+		var rows [][]string
+		for _, st := range stats {
+			scanned := "never"
+			if !st.Scanned.IsZero() {
+				scanned = st.Scanned.Format("2006-01-02 15:04")
+			}
+			rows = append(rows, []string{
+				st.Library,
+				st.Root,
+				strconv.Itoa(st.Folders),
+				strconv.Itoa(st.Files),
+				media.FormatFileSize(st.Bytes),
+				fmt.Sprintf("%d/%d", st.Probed, st.Probed+st.Pending),
+				scanned,
+			})
+		}
+		if len(rows) == 0 {
+			u.PrintInfo("index is empty, run 'raikiri index rebuild' or start the server")
+			return
+		}
+		u.PrintTable([]string{"Library", "Root", "Folders", "Files", "Size", "Probed", "Last Scan"}, rows)
+	},
+}
+
+func init() {
+	indexCmd.PersistentFlags().StringVarP(&indexFlags.data, "data", "d", ".raikiri", "Path to data directory (same as 'serve --data')")
+	indexRebuildCmd.Flags().StringVarP(&indexFlags.media, "media", "m", ".", "Path to media directory (same as 'serve --media')")
+	indexRebuildCmd.Flags().StringVarP(&indexFlags.music, "music", "M", "./music", "Path to music directory (same as 'serve --music')")
+	indexRebuildCmd.Flags().BoolVar(&indexFlags.probe, "probe", false, "Also run ffprobe on every audio and video file (otherwise the server does it in the background)")
+	indexCmd.AddCommand(indexRebuildCmd, indexStatsCmd)
+
+	rootCmd.AddCommand(indexCmd)
+}
@@ -1,20 +1,24 @@
 package prepare
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/spf13/cobra"
 
+	"github.com/tanq16/raikiri/internal/media"
 	"github.com/tanq16/raikiri/internal/thumbnails"
 	u "github.com/tanq16/raikiri/utils"
 )
 
 var Cmd = &cobra.Command{
 	Use:   "prepare",
-	Short: "Generate thumbnails and metadata for media files",
+	Short: "Generate thumbnails, metadata and optimized copies for media files",
 }
 
 func requireFFmpeg() {
@@ -135,11 +139,98 @@ Use --manual for interactive matching of the current directory to a specific mov
 	},
 }
 
+var optimizeFlags struct {
+	current bool
+	force   bool
+}
+
+var optimizeCmd = &cobra.Command{
+	Use:   "optimize [file]",
+	Short: "Pre-transcode videos that cannot be played directly",
+	Long: `Pre-transcode videos that cannot be played directly.
+
+Writes a faststart MP4 (H.264 up to 1080p, AAC stereo) next to each video that
+browsers cannot play as it is, as a hidden .<name>.optimized.mp4 file. The
+server plays these instead of transcoding, which suits weak server CPUs.
+Videos that already play directly are skipped.
+
+By default, processes all videos under the current directory recursively.
+Use --current to only process the current directory (non-recursive).
+Pass a single file path as an argument to optimize that file only.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		requireFFmpeg()
+		cwd := getCwd()
+
+		var files []string
+		if len(args) == 1 {
+			videoPath := args[0]
+			if !filepath.IsAbs(videoPath) {
+				videoPath = filepath.Join(cwd, videoPath)
+			}
+			if _, err := os.Stat(videoPath); os.IsNotExist(err) {
+				u.PrintFatal(fmt.Sprintf("video file not found: %s", videoPath), nil)
+			}
+			files = []string{videoPath}
+		} else {
+			files = listVideos(cwd, !optimizeFlags.current)
+			u.PrintInfo(fmt.Sprintf("found %d video files in '%s'", len(files), cwd))
+		}
+
+		optimized, skipped, failed := 0, 0, 0
+		for i, filePath := range files {
+			name := filepath.Base(filePath)
+			if _, ok := media.FindOptimized(filePath); ok && !optimizeFlags.force {
+				skipped++
+				continue
+			}
+			if !media.NeedsOptimize(filePath) {
+				skipped++
+				continue
+			}
+			u.PrintInfo(fmt.Sprintf("[%d/%d] optimizing: %s", i+1, len(files), name))
+			if err := media.Optimize(context.Background(), filePath); err != nil {
+				u.PrintError(fmt.Sprintf("optimizing %s failed", name), err)
+				failed++
+				continue
+			}
+			optimized++
+		}
+		u.PrintSuccess(fmt.Sprintf("complete: %d optimized, %d skipped, %d failed", optimized, skipped, failed))
+	},
+}
+
+// listVideos finds the videos under dir, skipping hidden files.
+func listVideos(dir string, recursive bool) []string {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && (!recursive || strings.HasPrefix(d.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasPrefix(d.Name(), ".") && slices.Contains(media.VideoExtensions, strings.ToLower(filepath.Ext(d.Name()))) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		u.PrintError("error walking directory", err)
+	}
+	return files
+}
+
 func init() {
 	thumbnailsCmd.Flags().BoolVar(&thumbnailsFlags.current, "current", false, "Only process the current directory (non-recursive)")
 	thumbnailsCmd.Flags().BoolVar(&thumbnailsFlags.force, "force", false, "Overwrite existing thumbnails (default: skip files that already have one)")
 	showsCmd.Flags().BoolVar(&showsFlags.manual, "manual", false, "Interactive matching for a single show")
 	moviesCmd.Flags().BoolVar(&moviesFlags.manual, "manual", false, "Interactive matching for a single movie")
+	optimizeCmd.Flags().BoolVar(&optimizeFlags.current, "current", false, "Only process the current directory (non-recursive)")
+	optimizeCmd.Flags().BoolVar(&optimizeFlags.force, "force", false, "Redo existing optimized copies (default: skip videos that already have one)")
 
-	Cmd.AddCommand(thumbnailsCmd, showsCmd, moviesCmd)
+	Cmd.AddCommand(thumbnailsCmd, showsCmd, moviesCmd, optimizeCmd)
 }
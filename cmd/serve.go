@@ -5,17 +5,31 @@ import (
 	"log"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 
+	"github.com/tanq16/raikiri/internal/cache"
 	"github.com/tanq16/raikiri/internal/server"
 )
 
 var serveFlags struct {
-	media string
-	music string
-	cache string
-	port  int
+	media   string
+	music   string
+	cache   string
+	data    string
+	port    int
+	scan    time.Duration
+	ladder  string
+	jobs    int
+	perUser int
+	idle    time.Duration
+
+	cacheSize     string
+	cacheAge      time.Duration
+	cacheInterval time.Duration
+
+	subtitleCharset string
 }
 
 var serveCmd = &cobra.Command{
@@ -25,11 +39,28 @@ var serveCmd = &cobra.Command{
 		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 		defer stop()
 
+		ladder, err := server.ParseLadder(serveFlags.ladder)
+		if err != nil {
+			return err
+		}
+		cacheSize, err := cache.ParseSize(serveFlags.cacheSize)
+		if err != nil {
+			return err
+		}
 		cfg := server.Config{
-			Port:      serveFlags.port,
-			MediaPath: serveFlags.media,
-			MusicPath: serveFlags.music,
-			CachePath: serveFlags.cache,
+			Port:              serveFlags.port,
+			MediaPath:         serveFlags.media,
+			MusicPath:         serveFlags.music,
+			CachePath:         serveFlags.cache,
+			DataPath:          serveFlags.data,
+			RescanInterval:    serveFlags.scan,
+			Ladder:            ladder,
+			MaxTranscodes:     serveFlags.jobs,
+			MaxUserStreams:    serveFlags.perUser,
+			StreamIdleTimeout: serveFlags.idle,
+			CachePolicy:       cache.Policy{MaxSize: cacheSize, MaxAge: serveFlags.cacheAge},
+			CacheInterval:     serveFlags.cacheInterval,
+			SubtitleCharset:   serveFlags.subtitleCharset,
 		}
 
 		srv := server.New(cfg)
@@ -47,5 +78,15 @@ func init() {
 	serveCmd.Flags().StringVarP(&serveFlags.media, "media", "m", ".", "Path to media directory")
 	serveCmd.Flags().StringVarP(&serveFlags.music, "music", "M", "./music", "Path to music directory")
 	serveCmd.Flags().StringVarP(&serveFlags.cache, "cache", "c", "/tmp", "Path to cache directory for HLS segments")
+	serveCmd.Flags().StringVarP(&serveFlags.data, "data", "d", ".raikiri", "Path to data directory for users and server state")
+	serveCmd.Flags().DurationVar(&serveFlags.scan, "rescan", 30*time.Minute, "Interval between full library index rescans (0 to rely on file watching only)")
+	serveCmd.Flags().StringVar(&serveFlags.ladder, "ladder", server.DefaultLadder, "Adaptive streaming renditions as height:kbps pairs (empty to disable)")
+	serveCmd.Flags().IntVar(&serveFlags.jobs, "max-transcodes", 2, "Concurrent ffmpeg streams before new ones queue, a remux counting as a quarter and viewers of one stream sharing it (0 for no limit)")
+	serveCmd.Flags().IntVar(&serveFlags.perUser, "max-user-streams", 2, "Streams each user can watch at once when authentication is enabled, joining a shared one included (0 for no limit)")
+	serveCmd.Flags().DurationVar(&serveFlags.idle, "stream-idle", 3*time.Minute, "Stop streams whose player has gone quiet for this long (0 to keep them until stopped)")
+	serveCmd.Flags().StringVar(&serveFlags.cacheSize, "cache-max-size", "10G", "Evict least recently used streams once the cache grows past this size (0 for no limit)")
+	serveCmd.Flags().DurationVar(&serveFlags.cacheAge, "cache-max-age", 72*time.Hour, "Evict streams unused for this long (0 for no limit)")
+	serveCmd.Flags().DurationVar(&serveFlags.cacheInterval, "cache-interval", time.Hour, "Interval between cache cleanups (0 to disable)")
+	serveCmd.Flags().StringVar(&serveFlags.subtitleCharset, "subtitle-charset", "", "Character set of subtitle files that are not UTF-8, such as CP1251 (empty to guess between GBK and Windows-1252)")
 	serveCmd.Flags().IntVarP(&serveFlags.port, "port", "p", 8080, "Port to listen on")
 }
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/tanq16/raikiri/internal/auth"
+	u "github.com/tanq16/raikiri/utils"
+)
+
+var tokenFlags struct {
+	data   string
+	user   string
+	name   string
+	scopes []string
+}
+
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Manage API tokens for apps and scripts",
+	Long: `Manage long-lived API tokens for apps and scripts.
+
+Tokens are sent as "Authorization: Bearer <token>" (or a "token" query parameter
+for media URLs) and are limited to their scopes:
+  browse  list libraries and fetch content
+  stream  start, play and stop video streams
+  upload  upload files`,
+}
+
+func openTokenStore(dataDir string) *auth.TokenStore {
+	store, err := auth.OpenTokens(dataDir)
+	if err != nil {
+		u.PrintFatal("failed to open token store", err)
+	}
+	return store
+}
+
+var tokenCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a token and print it once",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		users, err := auth.Open(tokenFlags.data)
+		if err != nil {
+			u.PrintFatal("failed to open user store", err)
+		}
+		if !users.Exists(tokenFlags.user) {
+			u.PrintFatal(fmt.Sprintf("user %s not found", tokenFlags.user), nil)
+		}
+		scopes, err := auth.ParseScopes(tokenFlags.scopes)
+		if err != nil {
+			u.PrintFatal("invalid scopes", err)
+		}
+		t, secret, err := openTokenStore(tokenFlags.data).Create(tokenFlags.user, tokenFlags.name, scopes)
+		if err != nil {
+			u.PrintFatal("failed to create token", err)
+		}
+		u.PrintSuccess(fmt.Sprintf("created token %s for %s (scopes: %s)", t.ID, t.User, strings.Join(t.Scopes, ", ")))
+		u.PrintWarn("store it now, it will not be shown again", nil)
+		u.PrintGeneric(secret)
+	},
+}
+
+var tokenListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List tokens",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		var rows [][]string
+		for _, t := range openTokenStore(tokenFlags.data).List(tokenFlags.user) {
+			rows = append(rows, []string{t.ID, t.Name, t.User, strings.Join(t.Scopes, ","), t.Created.Format("2006-01-02 15:04")})
+		}
+		if len(rows) == 0 {
+			u.PrintInfo("no tokens found")
+			return
+		}
+		u.PrintTable([]string{"ID", "Name", "User", "Scopes", "Created"}, rows)
+	},
+}
+
+var tokenRevokeCmd = &cobra.Command{
+	Use:   "revoke <id>",
+	Short: "Revoke a token by ID",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := openTokenStore(tokenFlags.data).Revoke(args[0], ""); err != nil {
+			u.PrintFatal("failed to revoke token", err)
+		}
+		u.PrintSuccess(fmt.Sprintf("revoked token %s", args[0]))
+	},
+}
+
+func init() {
+	tokenCmd.PersistentFlags().StringVarP(&tokenFlags.data, "data", "d", ".raikiri", "Path to data directory (same as 'serve --data')")
+	tokenCreateCmd.Flags().StringVarP(&tokenFlags.user, "user", "u", "", "User the token acts as")
+	tokenCreateCmd.Flags().StringVarP(&tokenFlags.name, "name", "n", "", "Label to identify the token")
+	tokenCreateCmd.Flags().StringSliceVarP(&tokenFlags.scopes, "scope", "s", nil, "Scopes to grant: browse, stream, upload or all (repeatable)")
+	tokenCreateCmd.MarkFlagRequired("user")
+	tokenCreateCmd.MarkFlagRequired("scope")
+	tokenListCmd.Flags().StringVarP(&tokenFlags.user, "user", "u", "", "Only list tokens of this user")
+	tokenCmd.AddCommand(tokenCreateCmd, tokenListCmd, tokenRevokeCmd)
+
+	rootCmd.AddCommand(tokenCmd)
+}
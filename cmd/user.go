@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/tanq16/raikiri/internal/auth"
+	u "github.com/tanq16/raikiri/utils"
+)
+
+var userFlags struct {
+	data string
+}
+
+var userCmd = &cobra.Command{
+	Use:   "user",
+	Short: "Manage user accounts for the server",
+	Long: `Manage user accounts for the server.
+
+Authentication is enforced by 'raikiri serve' as soon as at least one user exists.
+Changes apply to a running server without a restart.`,
+}
+
+func openUserStore() *auth.Store {
+	store, err := auth.Open(userFlags.data)
+	if err != nil {
+		u.PrintFatal("failed to open user store", err)
+	}
+	return store
+}
+
+func promptNewPassword() string {
+	password, err := u.PromptPassword("Password:")
+	if err != nil {
+		u.PrintFatal("failed to read password", err)
+	}
+	if u.GlobalForAIFlag {
+		return password
+	}
+	confirm, err := u.PromptPassword("Confirm password:")
+	if err != nil {
+		u.PrintFatal("failed to read password", err)
+	}
+	if password != confirm {
+		u.PrintFatal("passwords do not match", nil)
+	}
+	return password
+}
+
+var userAddCmd = &cobra.Command{
+	Use:   "add <username>",
+	Short: "Create a user account",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		store := openUserStore()
+		if store.Exists(args[0]) {
+			u.PrintFatal(fmt.Sprintf("user %s already exists", args[0]), nil)
+		}
+		if err := store.Add(args[0], promptNewPassword()); err != nil {
+			u.PrintFatal("failed to add user", err)
+		}
+		u.PrintSuccess(fmt.Sprintf("added user %s", args[0]))
+	},
+}
+
+var userRemoveCmd = &cobra.Command{
+	Use:   "remove <username>",
+	Short: "Delete a user account",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := openUserStore().Remove(args[0]); err != nil {
+			u.PrintFatal("failed to remove user", err)
+		}
+		removed, err := openTokenStore(userFlags.data).RevokeUser(args[0])
+		if err != nil {
+			u.PrintFatal("failed to revoke tokens", err)
+		}
+		u.PrintSuccess(fmt.Sprintf("removed user %s (revoked %d tokens)", args[0], removed))
+	},
+}
+
+var userPasswdCmd = &cobra.Command{
+	Use:   "passwd <username>",
+	Short: "Change the password of a user account",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		store := openUserStore()
+		if !store.Exists(args[0]) {
+			u.PrintFatal(fmt.Sprintf("user %s not found", args[0]), nil)
+		}
+		if err := store.SetPassword(args[0], promptNewPassword()); err != nil {
+			u.PrintFatal("failed to change password", err)
+		}
+		u.PrintSuccess(fmt.Sprintf("password changed for %s", args[0]))
+	},
+}
+
+var userListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List user accounts",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		var rows [][]string
+		for _, user := range openUserStore().List() {
+			rows = append(rows, []string{user.Name, user.Created.Format("2006-01-02 15:04")})
+		}
+		if len(rows) == 0 {
+			u.PrintInfo("no users configured")
+			return
+		}
+		u.PrintTable([]string{"User", "Created"}, rows)
+	},
+}
+
+func init() {
+	userCmd.PersistentFlags().StringVarP(&userFlags.data, "data", "d", ".raikiri", "Path to data directory (same as 'serve --data')")
+	userCmd.AddCommand(userAddCmd, userRemoveCmd, userPasswdCmd, userListCmd)
+
+	rootCmd.AddCommand(userCmd)
+}
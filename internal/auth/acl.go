@@ -0,0 +1,260 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/tanq16/raikiri/internal/store"
+)
+
+const aclFile = "acl.json"
+
+// Permissions granted by ACL rules.
+const (
+	PermRead   = "read"
+	PermUpload = "upload"
+)
+
+var AllPerms = []string{PermRead, PermUpload}
+
+// Rule grants permissions on a path prefix of a library to a subject. Subjects
+// are "user:<name>", "group:<name>" or "*" for every user. Library is "media",
+// "music" or "*". An empty Allow list denies access.
+type Rule struct {
+	Subject string   `json:"subject"`
+	Library string   `json:"library"`
+	Path    string   `json:"path"`
+	Allow   []string `json:"allow"`
+}
+
+type aclData struct {
+	Groups map[string][]string `json:"groups"`
+	Rules  []Rule              `json:"rules"`
+}
+
+// ACL evaluates access rules stored in acl.json. Without any rules everything
+// is allowed. Otherwise access is denied by default and, among the rules that
+// apply to a user and cover a path, the one with the longest path prefix wins;
+// ties go to user rules over group rules over "*".
+type ACL struct {
+	file store.JSONFile
+	mu   sync.RWMutex
+	data aclData
+}
+
+func OpenACL(dataDir string) (*ACL, error) {
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	a := &ACL{file: store.JSONFile{Path: filepath.Join(dataDir, aclFile)}}
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *ACL) load() error {
+	var data aclData
+	if err := a.file.Read(&data); err != nil {
+		return err
+	}
+	if data.Groups == nil {
+		data.Groups = make(map[string][]string)
+	}
+	for i := range data.Rules {
+		data.Rules[i].Path = normalizePath(data.Rules[i].Path)
+	}
+	a.data = data
+	return nil
+}
+
+func (a *ACL) refresh() {
+	a.mu.RLock()
+	changed := a.file.Changed()
+	a.mu.RUnlock()
+	if !changed {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.load()
+}
+
+func (a *ACL) save() error {
+	return a.file.Write(a.data)
+}
+
+// NormalizeLibrary maps a request mode to the library name used in rules.
+func NormalizeLibrary(mode string) string {
+	if mode == "music" {
+		return "music"
+	}
+	return "media"
+}
+
+func normalizePath(p string) string {
+	p = path.Clean("/" + strings.ReplaceAll(p, "\\", "/"))
+	return strings.Trim(p, "/")
+}
+
+func covers(prefix, p string) bool {
+	return prefix == "" || p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// subjectRank returns how specific a rule subject is for user, or -1 if the
+// rule does not apply to them.
+func (a *ACL) subjectRank(subject, user string) int {
+	switch {
+	case subject == "user:"+user:
+		return 2
+	case strings.HasPrefix(subject, "group:"):
+		if slices.Contains(a.data.Groups[strings.TrimPrefix(subject, "group:")], user) {
+			return 1
+		}
+	case subject == "*":
+		return 0
+	}
+	return -1
+}
+
+func (a *ACL) applies(r Rule, user, library string) (int, bool) {
+	if r.Library != "*" && r.Library != library {
+		return 0, false
+	}
+	rank := a.subjectRank(r.Subject, user)
+	return rank, rank >= 0
+}
+
+// Enabled reports whether any rule exists.
+func (a *ACL) Enabled() bool {
+	a.refresh()
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.data.Rules) > 0
+}
+
+// Allowed reports whether user holds perm on rel inside the library.
+func (a *ACL) Allowed(user, mode, rel, perm string) bool {
+	a.refresh()
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if len(a.data.Rules) == 0 {
+		return true
+	}
+	library := NormalizeLibrary(mode)
+	rel = normalizePath(rel)
+
+	var best *Rule
+	bestRank := -1
+	for i := range a.data.Rules {
+		r := &a.data.Rules[i]
+		rank, ok := a.applies(*r, user, library)
+		if !ok || !covers(r.Path, rel) {
+			continue
+		}
+		if best == nil || len(r.Path) > len(best.Path) || (len(r.Path) == len(best.Path) && rank > bestRank) {
+			best, bestRank = r, rank
+		}
+	}
+	return best != nil && slices.Contains(best.Allow, perm)
+}
+
+// CanTraverse reports whether user may see the folder rel in listings: either
+// it is readable itself or some rule grants read access somewhere beneath it.
+func (a *ACL) CanTraverse(user, mode, rel string) bool {
+	if a.Allowed(user, mode, rel, PermRead) {
+		return true
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	library := NormalizeLibrary(mode)
+	rel = normalizePath(rel)
+	for _, r := range a.data.Rules {
+		if _, ok := a.applies(r, user, library); !ok {
+			continue
+		}
+		if r.Path != rel && covers(rel, r.Path) && slices.Contains(r.Allow, PermRead) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *ACL) Rules() []Rule {
+	a.refresh()
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return slices.Clone(a.data.Rules)
+}
+
+func (a *ACL) Groups() map[string][]string {
+	a.refresh()
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	groups := make(map[string][]string, len(a.data.Groups))
+	for g, members := range a.data.Groups {
+		groups[g] = slices.Clone(members)
+	}
+	return groups
+}
+
+func validateRule(r Rule) error {
+	if r.Subject != "*" && !strings.HasPrefix(r.Subject, "user:") && !strings.HasPrefix(r.Subject, "group:") {
+		return fmt.Errorf("invalid subject %q (use user:<name>, group:<name> or *)", r.Subject)
+	}
+	if r.Library != "*" && r.Library != "media" && r.Library != "music" {
+		return fmt.Errorf("invalid library %q (use media, music or *)", r.Library)
+	}
+	for _, p := range r.Allow {
+		if !slices.Contains(AllPerms, p) {
+			return fmt.Errorf("unknown permission %q (valid: %s)", p, strings.Join(AllPerms, ", "))
+		}
+	}
+	return nil
+}
+
+func (a *ACL) AddRule(r Rule) error {
+	r.Path = normalizePath(r.Path)
+	if err := validateRule(r); err != nil {
+		return err
+	}
+	a.refresh()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.data.Rules = append(a.data.Rules, r)
+	return a.save()
+}
+
+// RemoveRule deletes the rule at the given zero-based index.
+func (a *ACL) RemoveRule(index int) error {
+	a.refresh()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if index < 0 || index >= len(a.data.Rules) {
+		return errors.New("rule index out of range")
+	}
+	a.data.Rules = slices.Delete(a.data.Rules, index, index+1)
+	return a.save()
+}
+
+// SetGroup replaces the members of a group; an empty member list deletes it.
+func (a *ACL) SetGroup(group string, members []string) error {
+	if group == "" {
+		return errors.New("group name cannot be empty")
+	}
+	a.refresh()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(members) == 0 {
+		delete(a.data.Groups, group)
+	} else {
+		a.data.Groups[group] = members
+	}
+	return a.save()
+}
@@ -0,0 +1,68 @@
+package auth
+
+import "testing"
+
+func TestACLAllowed(t *testing.T) {
+	a, err := OpenACL(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.Allowed("bob", "videos", "Private/a.mkv", PermRead) {
+		t.Fatal("Allowed() = false without rules, want true")
+	}
+	if err := a.SetGroup("kids", []string{"bob", "carol"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []Rule{
+		{Subject: "*", Library: "media", Allow: []string{PermRead}},
+		{Subject: "*", Library: "media", Path: "Private"},
+		{Subject: "group:kids", Library: "media"},
+		{Subject: "group:kids", Library: "media", Path: "Kids", Allow: []string{PermRead}},
+		{Subject: "user:carol", Library: "media", Path: "Kids", Allow: []string{PermRead, PermUpload}},
+		{Subject: "user:alice", Library: "*", Allow: []string{PermRead, PermUpload}},
+		{Subject: "*", Library: "media", Path: "Movies/Private", Allow: []string{PermRead}},
+	} {
+		if err := a.AddRule(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		user, mode, rel, perm string
+		want                  bool
+	}{
+		{"dave", "videos", "Movies/a.mkv", PermRead, true},
+		{"dave", "videos", "Movies/a.mkv", PermUpload, false},
+		{"dave", "videos", "Private", PermRead, false},
+		{"dave", "videos", "Private/a.mkv", PermRead, false},
+		// A prefix only covers whole path segments.
+		{"dave", "videos", "Private2/a.mkv", PermRead, true},
+		// The longer rule wins even when less specific in subject.
+		{"alice", "videos", "Private/a.mkv", PermRead, false},
+		{"alice", "videos", "Movies/Private/a.mkv", PermUpload, false},
+		{"alice", "videos", "Movies/a.mkv", PermUpload, true},
+		{"alice", "music", "Albums/a.flac", PermUpload, true},
+		{"dave", "music", "Albums/a.flac", PermRead, false},
+		// On equal paths, user rules beat group rules, which beat "*".
+		{"bob", "videos", "Movies/a.mkv", PermRead, false},
+		{"bob", "videos", "Kids/a.mkv", PermRead, true},
+		{"bob", "videos", "Kids/a.mkv", PermUpload, false},
+		{"carol", "videos", "Kids/a.mkv", PermUpload, true},
+		{"bob", "videos", "Movies/Private/a.mkv", PermRead, true},
+		// Paths are cleaned before matching.
+		{"dave", "videos", "/Movies/../Private/a.mkv", PermRead, false},
+		{"dave", "videos", `Private\a.mkv`, PermRead, false},
+	}
+	for _, tt := range tests {
+		if got := a.Allowed(tt.user, tt.mode, tt.rel, tt.perm); got != tt.want {
+			t.Errorf("Allowed(%q, %q, %q, %q) = %v, want %v", tt.user, tt.mode, tt.rel, tt.perm, got, tt.want)
+		}
+	}
+
+	if !a.CanTraverse("bob", "videos", "") {
+		t.Error("CanTraverse(bob, root) = false, want true to reach Kids")
+	}
+	if a.CanTraverse("bob", "videos", "Shows") {
+		t.Error("CanTraverse(bob, Shows) = true, want false")
+	}
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+const SessionTTL = 30 * 24 * time.Hour
+
+type session struct {
+	user    string
+	expires time.Time
+}
+
+// Sessions holds browser login sessions in memory. Restarting the server
+// signs everyone out.
+type Sessions struct {
+	mu       sync.Mutex
+	sessions map[string]session
+}
+
+func NewSessions() *Sessions {
+	return &Sessions{sessions: make(map[string]session)}
+}
+
+func randomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func (s *Sessions) Create(user string) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[token] = session{user: user, expires: time.Now().Add(SessionTTL)}
+	return token, nil
+}
+
+// Lookup returns the user owning token and extends the session on success.
+func (s *Sessions) Lookup(token string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[token]
+	if !ok {
+		return "", false
+	}
+	now := time.Now()
+	if now.After(sess.expires) {
+		delete(s.sessions, token)
+		return "", false
+	}
+	sess.expires = now.Add(SessionTTL)
+	s.sessions[token] = sess
+	return sess.user, true
+}
+
+func (s *Sessions) Revoke(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, token)
+}
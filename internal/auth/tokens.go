@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tanq16/raikiri/internal/store"
+)
+
+const tokensFile = "tokens.json"
+
+const tokenPrefix = "rk_"
+
+// Scopes limit what an API token may do. Browser sessions carry all of them.
+const (
+	ScopeBrowse = "browse"
+	ScopeStream = "stream"
+	ScopeUpload = "upload"
+)
+
+var AllScopes = []string{ScopeBrowse, ScopeStream, ScopeUpload}
+
+var ErrTokenNotFound = errors.New("token not found")
+
+// Token is a long-lived bearer credential for apps and scripts. Only the
+// SHA-256 of the secret is stored; the secret itself is shown once on creation.
+type Token struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	User    string    `json:"user"`
+	Scopes  []string  `json:"scopes"`
+	Hash    string    `json:"hash"`
+	Created time.Time `json:"created"`
+}
+
+type TokenStore struct {
+	file   store.JSONFile
+	mu     sync.RWMutex
+	tokens map[string]*Token // keyed by hash
+}
+
+func OpenTokens(dataDir string) (*TokenStore, error) {
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	s := &TokenStore{
+		file:   store.JSONFile{Path: filepath.Join(dataDir, tokensFile)},
+		tokens: make(map[string]*Token),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *TokenStore) load() error {
+	var list []*Token
+	if err := s.file.Read(&list); err != nil {
+		return err
+	}
+	tokens := make(map[string]*Token, len(list))
+	for _, t := range list {
+		tokens[t.Hash] = t
+	}
+	s.tokens = tokens
+	return nil
+}
+
+func (s *TokenStore) refresh() {
+	s.mu.RLock()
+	changed := s.file.Changed()
+	s.mu.RUnlock()
+	if !changed {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load()
+}
+
+func (s *TokenStore) save() error {
+	return s.file.Write(s.sorted())
+}
+
+func (s *TokenStore) sorted() []*Token {
+	list := make([]*Token, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return list
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseScopes validates a list of scope names, accepting comma-separated
+// values and "all" as a shorthand.
+func ParseScopes(values []string) ([]string, error) {
+	var scopes []string
+	for _, v := range values {
+		for part := range strings.SplitSeq(v, ",") {
+			part = strings.TrimSpace(strings.ToLower(part))
+			if part == "" {
+				continue
+			}
+			if part == "all" {
+				return slices.Clone(AllScopes), nil
+			}
+			if !slices.Contains(AllScopes, part) {
+				return nil, fmt.Errorf("unknown scope %q (valid: %s)", part, strings.Join(AllScopes, ", "))
+			}
+			if !slices.Contains(scopes, part) {
+				scopes = append(scopes, part)
+			}
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+	return scopes, nil
+}
+
+// Create issues a new token and returns it together with the plaintext secret.
+func (s *TokenStore) Create(user, name string, scopes []string) (*Token, string, error) {
+	random, err := randomToken(24)
+	if err != nil {
+		return nil, "", err
+	}
+	secret := tokenPrefix + random
+	hash := hashToken(secret)
+	t := &Token{
+		ID:      hash[:8],
+		Name:    name,
+		User:    user,
+		Scopes:  scopes,
+		Hash:    hash,
+		Created: time.Now(),
+	}
+	s.refresh()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[hash] = t
+	if err := s.save(); err != nil {
+		return nil, "", err
+	}
+	copied := *t
+	return &copied, secret, nil
+}
+
+// Lookup resolves a presented secret. Secrets without the token prefix are
+// rejected early so session IDs never hit the token table.
+func (s *TokenStore) Lookup(secret string) (*Token, bool) {
+	if !strings.HasPrefix(secret, tokenPrefix) {
+		return nil, false
+	}
+	s.refresh()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.tokens[hashToken(secret)]
+	if !ok {
+		return nil, false
+	}
+	copied := *t
+	return &copied, true
+}
+
+// List returns tokens owned by user, or every token when user is empty.
+func (s *TokenStore) List(user string) []Token {
+	s.refresh()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var list []Token
+	for _, t := range s.sorted() {
+		if user == "" || t.User == user {
+			list = append(list, *t)
+		}
+	}
+	return list
+}
+
+// Revoke deletes the token with the given ID. A non-empty user restricts the
+// operation to that user's tokens.
+func (s *TokenStore) Revoke(id, user string) error {
+	s.refresh()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, t := range s.tokens {
+		if t.ID == id && (user == "" || t.User == user) {
+			delete(s.tokens, hash)
+			return s.save()
+		}
+	}
+	return ErrTokenNotFound
+}
+
+// RevokeUser deletes every token owned by user and returns how many were removed.
+func (s *TokenStore) RevokeUser(user string) (int, error) {
+	s.refresh()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := 0
+	for hash, t := range s.tokens {
+		if t.User == user {
+			delete(s.tokens, hash)
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, s.save()
+}
@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/tanq16/raikiri/internal/store"
+)
+
+const usersFile = "users.json"
+
+var (
+	ErrUserExists         = errors.New("user already exists")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrInvalidCredentials = errors.New("invalid username or password")
+)
+
+type User struct {
+	Name         string    `json:"name"`
+	PasswordHash string    `json:"passwordHash"`
+	Created      time.Time `json:"created"`
+}
+
+// Store keeps user accounts in a JSON file inside the data directory. The file
+// is re-read whenever its modification time changes so that `raikiri user`
+// commands take effect on a running server without a restart.
+type Store struct {
+	file  store.JSONFile
+	mu    sync.RWMutex
+	users map[string]*User
+}
+
+func Open(dataDir string) (*Store, error) {
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	s := &Store{
+		file:  store.JSONFile{Path: filepath.Join(dataDir, usersFile)},
+		users: make(map[string]*User),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) load() error {
+	var list []*User
+	if err := s.file.Read(&list); err != nil {
+		return err
+	}
+	users := make(map[string]*User, len(list))
+	for _, u := range list {
+		users[u.Name] = u
+	}
+	s.users = users
+	return nil
+}
+
+// refresh reloads the users file if another process modified it.
+func (s *Store) refresh() {
+	s.mu.RLock()
+	changed := s.file.Changed()
+	s.mu.RUnlock()
+	if !changed {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// A half-written or invalid file keeps the previously loaded users.
+	s.load()
+}
+
+func (s *Store) save() error {
+	list := make([]*User, 0, len(s.users))
+	for _, u := range s.users {
+		list = append(list, u)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return s.file.Write(list)
+}
+
+func validateName(name string) error {
+	if name == "" {
+		return errors.New("username cannot be empty")
+	}
+	if strings.ContainsAny(name, " \t\n:/") {
+		return errors.New("username cannot contain whitespace, ':' or '/'")
+	}
+	return nil
+}
+
+func hashPassword(password string) (string, error) {
+	if password == "" {
+		return "", errors.New("password cannot be empty")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// HasUsers reports whether any account exists. The server only enforces
+// authentication once at least one user has been created.
+func (s *Store) HasUsers() bool {
+	s.refresh()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.users) > 0
+}
+
+func (s *Store) Exists(name string) bool {
+	s.refresh()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.users[name]
+	return ok
+}
+
+func (s *Store) List() []User {
+	s.refresh()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]User, 0, len(s.users))
+	for _, u := range s.users {
+		list = append(list, *u)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func (s *Store) Add(name, password string) error {
+	if err := validateName(name); err != nil {
+		return err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	s.refresh()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[name]; ok {
+		return ErrUserExists
+	}
+	s.users[name] = &User{Name: name, PasswordHash: hash, Created: time.Now()}
+	return s.save()
+}
+
+func (s *Store) Remove(name string) error {
+	s.refresh()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[name]; !ok {
+		return ErrUserNotFound
+	}
+	delete(s.users, name)
+	return s.save()
+}
+
+func (s *Store) SetPassword(name, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	s.refresh()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[name]
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.PasswordHash = hash
+	return s.save()
+}
+
+func (s *Store) Authenticate(name, password string) (*User, error) {
+	s.refresh()
+	s.mu.RLock()
+	u, ok := s.users[name]
+	s.mu.RUnlock()
+	if !ok {
+		// Compare against a dummy hash so unknown users take as long as known ones.
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	copied := *u
+	return &copied, nil
+}
+
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("raikiri"), bcrypt.DefaultCost)
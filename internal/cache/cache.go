@@ -0,0 +1,196 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stream directories in the cache: "s_<unix nanos>" sessions belong to one
+// viewer and "t_<24 hex digits>" streams are shared and kept for replays.
+// Directories being removed are renamed with a "x_" prefix first. Only
+// names of exactly these shapes are touched, as the cache directory may be
+// shared with other programs (/tmp by default).
+const (
+	sharedPrefix  = "t_"
+	retiredPrefix = "x_"
+)
+
+var (
+	sessionName = regexp.MustCompile(`^s_[0-9]+$`)
+	sharedName  = regexp.MustCompile(`^t_[0-9a-f]{24}$`)
+)
+
+// InfoFile describes what a shared stream was made from.
+const InfoFile = "stream.json"
+
+type Info struct {
+	File   string `json:"file"`
+	Source string `json:"source"`
+	Audio  int    `json:"audio"`
+}
+
+// WriteInfo records what the stream in dir was made from, for stats.
+func WriteInfo(dir string, info Info) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, InfoFile), data, 0644)
+}
+
+// Entry is one stream directory in the cache.
+type Entry struct {
+	Name string
+	Size int64
+	// LastUsed is the modification time of the directory, which the server
+	// bumps whenever a stream is opened or its last viewer leaves.
+	LastUsed time.Time
+	Shared   bool
+	Info     Info
+}
+
+// IsStreamDir reports whether name is a stream directory.
+func IsStreamDir(name string) bool {
+	return IsSessionDir(name) || sharedName.MatchString(name)
+}
+
+// IsSessionDir reports whether name is the directory of a single viewer's
+// session.
+func IsSessionDir(name string) bool {
+	return sessionName.MatchString(name)
+}
+
+// IsRetired reports whether name is a stream directory left half removed.
+func IsRetired(name string) bool {
+	rest, ok := strings.CutPrefix(name, retiredPrefix)
+	return ok && IsStreamDir(rest)
+}
+
+// Scan lists the stream directories in root with their sizes.
+func Scan(root string) ([]Entry, error) {
+	dirEntries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for _, de := range dirEntries {
+		if !de.IsDir() || !IsStreamDir(de.Name()) {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		e := Entry{
+			Name:     de.Name(),
+			Size:     dirSize(filepath.Join(root, de.Name())),
+			LastUsed: info.ModTime(),
+			Shared:   strings.HasPrefix(de.Name(), sharedPrefix),
+		}
+		if data, err := os.ReadFile(filepath.Join(root, de.Name(), InfoFile)); err == nil {
+			json.Unmarshal(data, &e.Info)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// Policy bounds what the cache keeps. Zero values disable a bound.
+type Policy struct {
+	MaxSize int64
+	MaxAge  time.Duration
+}
+
+// Select picks the entries to evict: everything unused for longer than
+// MaxAge, then the least recently used until the rest fits in MaxSize.
+// Entries inUse reports as in use are never picked; inUse may be nil.
+func (p Policy) Select(entries []Entry, now time.Time, inUse func(name string) bool) []Entry {
+	sorted := make([]Entry, len(entries))
+	copy(sorted, entries)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].LastUsed.Before(sorted[j].LastUsed) })
+	var total int64
+	for _, e := range sorted {
+		total += e.Size
+	}
+	var evict []Entry
+	for _, e := range sorted {
+		if inUse != nil && inUse(e.Name) {
+			continue
+		}
+		expired := p.MaxAge > 0 && now.Sub(e.LastUsed) > p.MaxAge
+		if expired || (p.MaxSize > 0 && total > p.MaxSize) {
+			evict = append(evict, e)
+			total -= e.Size
+		}
+	}
+	return evict
+}
+
+// Retire renames the stream directory name out of the way, so a stream
+// opened right after cannot pick it up, and returns where it went for the
+// caller to remove.
+func Retire(root, name string) (string, error) {
+	dst := filepath.Join(root, retiredPrefix+name)
+	if err := os.Rename(filepath.Join(root, name), dst); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+// Remove retires and deletes the stream directory name.
+func Remove(root, name string) error {
+	dir, err := Retire(root, name)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// ParseSize reads a size such as "500M", "20G" or "1.5T" (binary units, a
+// trailing "B" or "iB" allowed); a bare number is bytes.
+func ParseSize(s string) (int64, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	str = strings.TrimSuffix(strings.TrimSuffix(str, "IB"), "B")
+	mult := int64(1)
+	if n := len(str); n > 0 {
+		switch str[n-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		case 'T':
+			mult = 1 << 40
+		}
+		if mult > 1 {
+			str = str[:n-1]
+		}
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(v * float64(mult)), nil
+}
@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStreamDirNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		stream  bool
+		session bool
+		retired bool
+	}{
+		{"s_1712345678901234567", true, true, false},
+		{"t_0123456789abcdef01234567", true, false, false},
+		{"x_s_1712345678901234567", false, false, true},
+		{"x_t_0123456789abcdef01234567", false, false, true},
+		{"s_", false, false, false},
+		{"s_12ab", false, false, false},
+		{"s_systemd-private", false, false, false},
+		{"t_0123456789abcdef0123456", false, false, false},
+		{"t_0123456789abcdef012345678", false, false, false},
+		{"t_0123456789ABCDEF01234567", false, false, false},
+		{"t_mux", false, false, false},
+		{"x_", false, false, false},
+		{"x_server_tmp", false, false, false},
+		{"x_x_s_1", false, false, false},
+		{"fonts_0123", false, false, false},
+	}
+	for _, tt := range tests {
+		if got := IsStreamDir(tt.name); got != tt.stream {
+			t.Errorf("IsStreamDir(%q) = %v, want %v", tt.name, got, tt.stream)
+		}
+		if got := IsSessionDir(tt.name); got != tt.session {
+			t.Errorf("IsSessionDir(%q) = %v, want %v", tt.name, got, tt.session)
+		}
+		if got := IsRetired(tt.name); got != tt.retired {
+			t.Errorf("IsRetired(%q) = %v, want %v", tt.name, got, tt.retired)
+		}
+	}
+}
+
+func TestPolicySelect(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	entries := []Entry{
+		{Name: "t_c", Size: 300, LastUsed: now.Add(-1 * time.Hour)},
+		{Name: "t_a", Size: 100, LastUsed: now.Add(-3 * time.Hour)},
+		{Name: "t_b", Size: 200, LastUsed: now.Add(-2 * time.Hour)},
+	}
+	tests := []struct {
+		name   string
+		policy Policy
+		inUse  []string
+		want   []string
+	}{
+		{"no bounds", Policy{}, nil, nil},
+		{"fits", Policy{MaxSize: 600}, nil, nil},
+		{"oldest first", Policy{MaxSize: 500}, nil, []string{"t_a"}},
+		{"until it fits", Policy{MaxSize: 300}, nil, []string{"t_a", "t_b"}},
+		{"skips in use", Policy{MaxSize: 250}, []string{"t_a"}, []string{"t_b", "t_c"}},
+		{"expired", Policy{MaxAge: 90 * time.Minute}, nil, []string{"t_a", "t_b"}},
+		{"expired in use", Policy{MaxAge: 90 * time.Minute}, []string{"t_b"}, []string{"t_a"}},
+		{"age then size", Policy{MaxSize: 350, MaxAge: 150 * time.Minute}, nil, []string{"t_a", "t_b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inUse := func(name string) bool {
+				for _, n := range tt.inUse {
+					if n == name {
+						return true
+					}
+				}
+				return false
+			}
+			var got []string
+			for _, e := range tt.policy.Select(entries, now, inUse) {
+				got = append(got, e.Name)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Select() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("Select() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,307 @@
+package index
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/tanq16/raikiri/internal/media"
+)
+
+const indexFile = "index.db"
+
+var (
+	metaBucket     = []byte("meta")
+	probeBucket    = []byte("probes")
+	keyframeBucket = []byte("keyframes")
+	quoteBucket    = []byte("quotes")
+)
+
+var (
+	ErrLocked   = errors.New("index is locked by another process (is 'raikiri serve' running?)")
+	ErrNotFound = errors.New("path not found in index")
+)
+
+// Library is a named media root, "media" or "music".
+type Library struct {
+	Name string
+	Root string
+}
+
+// MediaInfo holds the ffprobe facts recorded for audio and video files.
+type MediaInfo struct {
+	Duration    float64  `json:"duration,omitempty"`
+	Container   string   `json:"container,omitempty"`
+	VideoCodec  string   `json:"videoCodec,omitempty"`
+	Width       int      `json:"width,omitempty"`
+	Height      int      `json:"height,omitempty"`
+	AudioCodecs []string `json:"audioCodecs,omitempty"`
+	Subtitles   int      `json:"subtitles,omitempty"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// Entry is one indexed file or folder. Path is relative to the library root
+// with forward slashes.
+type Entry struct {
+	Path    string     `json:"path"`
+	Name    string     `json:"name"`
+	Type    string     `json:"type"`
+	Size    int64      `json:"size"`
+	ModTime time.Time  `json:"modTime"`
+	Media   *MediaInfo `json:"media,omitempty"`
+}
+
+func (e Entry) IsDir() bool {
+	return e.Type == "folder"
+}
+
+// probeable reports whether ffprobe facts are worth collecting for the entry.
+func (e Entry) probeable() bool {
+	return e.Type == "video" || e.Type == "audio"
+}
+
+// sameFile reports whether two entries describe the same version of a file.
+func (e Entry) sameFile(o Entry) bool {
+	return e.Type == o.Type && e.Size == o.Size && e.ModTime.Equal(o.ModTime)
+}
+
+func NewEntry(rel string, info fs.FileInfo) Entry {
+	e := Entry{
+		Path:    rel,
+		Name:    info.Name(),
+		Type:    media.GetFileType(info.Name(), info.IsDir()),
+		ModTime: info.ModTime(),
+	}
+	if !info.IsDir() {
+		e.Size = info.Size()
+	}
+	return e
+}
+
+type libraryMeta struct {
+	Root    string    `json:"root"`
+	Scanned time.Time `json:"scanned"`
+}
+
+// Index is an on-disk catalogue of every library entry, stored in index.db
+// in the data directory with one bucket per library. Keys are the parent
+// folder and name separated by a NUL byte so a folder's children sit next to
+// each other and can be listed with a single prefix scan.
+type Index struct {
+	db *bolt.DB
+	// reading is held by ProbePending and IndexQuotes, so the periodic
+	// rescan and the watcher never read the same files at once; whichever
+	// runs second finds them done.
+	reading sync.Mutex
+}
+
+func Open(dataDir string) (*Index, error) {
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	db, err := bolt.Open(filepath.Join(dataDir, indexFile), 0600, &bolt.Options{Timeout: time.Second})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, ErrLocked
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open index: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(metaBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(probeBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(keyframeBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(quoteBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize index: %w", err)
+	}
+	return &Index{db: db}, nil
+}
+
+func (x *Index) Close() error {
+	return x.db.Close()
+}
+
+func key(rel string) []byte {
+	dir, name := path.Split(rel)
+	return []byte(strings.TrimSuffix(dir, "/") + "\x00" + name)
+}
+
+// subtreePrefixes returns the key prefixes covering everything beneath dir:
+// its direct children and the children of its descendants.
+func subtreePrefixes(dir string) [][]byte {
+	if dir == "" {
+		return [][]byte{nil}
+	}
+	return [][]byte{[]byte(dir + "\x00"), []byte(dir + "/")}
+}
+
+func scanPrefix(b *bolt.Bucket, prefix []byte, fn func(k, v []byte) error) error {
+	c := b.Cursor()
+	k, v := c.First()
+	if len(prefix) > 0 {
+		k, v = c.Seek(prefix)
+	}
+	for ; k != nil && strings.HasPrefix(string(k), string(prefix)); k, v = c.Next() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decode(v []byte) (Entry, error) {
+	var e Entry
+	err := json.Unmarshal(v, &e)
+	return e, err
+}
+
+func put(b *bolt.Bucket, e Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return b.Put(key(e.Path), data)
+}
+
+func readMeta(tx *bolt.Tx, library string) (libraryMeta, bool) {
+	var meta libraryMeta
+	v := tx.Bucket(metaBucket).Get([]byte(library))
+	if v == nil || json.Unmarshal(v, &meta) != nil {
+		return meta, false
+	}
+	return meta, true
+}
+
+// Ready reports whether library has completed a full scan of root, so
+// listings can be served from the index.
+func (x *Index) Ready(library, root string) bool {
+	ready := false
+	x.db.View(func(tx *bolt.Tx) error {
+		meta, ok := readMeta(tx, library)
+		ready = ok && meta.Root == root && tx.Bucket([]byte(library)) != nil
+		return nil
+	})
+	return ready
+}
+
+func (x *Index) Get(library, rel string) (Entry, bool) {
+	var e Entry
+	found := false
+	x.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(library))
+		if b == nil {
+			return nil
+		}
+		if v := b.Get(key(rel)); v != nil {
+			var err error
+			e, err = decode(v)
+			found = err == nil
+		}
+		return nil
+	})
+	return e, found
+}
+
+// List returns the entries inside dir ("" for the library root), including
+// everything beneath it when recursive is set.
+func (x *Index) List(library, dir string, recursive bool) ([]Entry, error) {
+	var entries []Entry
+	err := x.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(library))
+		if b == nil {
+			return ErrNotFound
+		}
+		if dir != "" {
+			v := b.Get(key(dir))
+			if v == nil {
+				return ErrNotFound
+			}
+			if e, err := decode(v); err != nil || !e.IsDir() {
+				return ErrNotFound
+			}
+		}
+		prefixes := subtreePrefixes(dir)
+		if !recursive {
+			prefixes = [][]byte{[]byte(dir + "\x00")}
+		}
+		for _, prefix := range prefixes {
+			err := scanPrefix(b, prefix, func(k, v []byte) error {
+				e, err := decode(v)
+				if err != nil {
+					return nil
+				}
+				entries = append(entries, e)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return entries, err
+}
+
+type LibraryStats struct {
+	Library string
+	Root    string
+	Scanned time.Time
+	Folders int
+	Files   int
+	Bytes   int64
+	Probed  int
+	Pending int
+}
+
+func (x *Index) Stats() ([]LibraryStats, error) {
+	var stats []LibraryStats
+	err := x.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).ForEach(func(k, _ []byte) error {
+			meta, _ := readMeta(tx, string(k))
+			st := LibraryStats{Library: string(k), Root: meta.Root, Scanned: meta.Scanned}
+			if b := tx.Bucket(k); b != nil {
+				b.ForEach(func(_, v []byte) error {
+					e, err := decode(v)
+					if err != nil {
+						return nil
+					}
+					if e.IsDir() {
+						st.Folders++
+						return nil
+					}
+					st.Files++
+					st.Bytes += e.Size
+					if e.probeable() {
+						if e.Media != nil {
+							st.Probed++
+						} else {
+							st.Pending++
+						}
+					}
+					return nil
+				})
+			}
+			stats = append(stats, st)
+			return nil
+		})
+	})
+	return stats, err
+}
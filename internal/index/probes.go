@@ -0,0 +1,98 @@
+package index
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/tanq16/raikiri/internal/video"
+)
+
+// probeVersion is bumped whenever video.FFProbeOutput gains fields, so
+// results stored without them are probed again.
+const probeVersion = 2
+
+type storedProbe struct {
+	Version int                  `json:"version,omitempty"`
+	Size    int64                `json:"size"`
+	ModTime time.Time            `json:"modTime"`
+	Data    *video.FFProbeOutput `json:"data"`
+}
+
+// LoadProbe implements media.ProbeStore, keeping full ffprobe results keyed
+// by absolute path in the probes bucket.
+func (x *Index) LoadProbe(path string, size int64, modTime time.Time) (*video.FFProbeOutput, bool) {
+	var stored storedProbe
+	found := false
+	x.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(probeBucket).Get([]byte(path))
+		if v == nil || json.Unmarshal(v, &stored) != nil {
+			return nil
+		}
+		found = stored.Version == probeVersion && stored.Size == size && stored.ModTime.Equal(modTime) && stored.Data != nil
+		return nil
+	})
+	return stored.Data, found
+}
+
+// SaveProbe implements media.ProbeStore. Failures only cost a later re-probe,
+// so they are not reported.
+func (x *Index) SaveProbe(path string, size int64, modTime time.Time, data *video.FFProbeOutput) {
+	v, err := json.Marshal(storedProbe{Version: probeVersion, Size: size, ModTime: modTime, Data: data})
+	if err != nil {
+		return
+	}
+	x.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(probeBucket).Put([]byte(path), v)
+	})
+}
+
+// keyframeVersion is bumped whenever media changes how keyframe times are
+// read, so times stored the old way are read again.
+const keyframeVersion = 1
+
+type storedKeyframes struct {
+	Version   int       `json:"version,omitempty"`
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"modTime"`
+	Keyframes []float64 `json:"keyframes"`
+}
+
+// LoadKeyframes implements media.KeyframeStore.
+func (x *Index) LoadKeyframes(path string, size int64, modTime time.Time) ([]float64, bool) {
+	var stored storedKeyframes
+	found := false
+	x.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(keyframeBucket).Get([]byte(path))
+		if v == nil || json.Unmarshal(v, &stored) != nil {
+			return nil
+		}
+		found = stored.Version == keyframeVersion && stored.Size == size && stored.ModTime.Equal(modTime) && len(stored.Keyframes) > 0
+		return nil
+	})
+	return stored.Keyframes, found
+}
+
+// SaveKeyframes implements media.KeyframeStore.
+func (x *Index) SaveKeyframes(path string, size int64, modTime time.Time, keyframes []float64) {
+	v, err := json.Marshal(storedKeyframes{Version: keyframeVersion, Size: size, ModTime: modTime, Keyframes: keyframes})
+	if err != nil {
+		return
+	}
+	x.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(keyframeBucket).Put([]byte(path), v)
+	})
+}
+
+// dropProbe forgets the stored probe, keyframes and dialogue of a file that
+// left the library.
+func dropProbe(tx *bolt.Tx, root, rel string) {
+	if rel != "" {
+		key := []byte(filepath.Join(root, filepath.FromSlash(rel)))
+		tx.Bucket(probeBucket).Delete(key)
+		tx.Bucket(keyframeBucket).Delete(key)
+		tx.Bucket(quoteBucket).Delete(key)
+	}
+}
@@ -0,0 +1,51 @@
+package index
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestStoredKeyframes(t *testing.T) {
+	x, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+
+	modTime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	keyframes := []float64{0, 4.17, 8.34}
+	x.SaveKeyframes("/media/a.mkv", 100, modTime, keyframes)
+	// Stored before keyframes were versioned.
+	v, _ := json.Marshal(storedKeyframes{Size: 100, ModTime: modTime, Keyframes: keyframes})
+	x.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(keyframeBucket).Put([]byte("/media/old.mkv"), v)
+	})
+
+	tests := []struct {
+		name    string
+		path    string
+		size    int64
+		modTime time.Time
+		found   bool
+	}{
+		{"current", "/media/a.mkv", 100, modTime, true},
+		{"resized", "/media/a.mkv", 101, modTime, false},
+		{"modified", "/media/a.mkv", 100, modTime.Add(time.Second), false},
+		{"old version", "/media/old.mkv", 100, modTime, false},
+		{"missing", "/media/b.mkv", 100, modTime, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := x.LoadKeyframes(tt.path, tt.size, tt.modTime)
+			if found != tt.found {
+				t.Fatalf("LoadKeyframes() found = %v, want %v", found, tt.found)
+			}
+			if found && len(got) != len(keyframes) {
+				t.Errorf("LoadKeyframes() = %v, want %v", got, keyframes)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package index
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/tanq16/raikiri/internal/media"
+)
+
+// quoteVersion is bumped whenever the way dialogue is read changes, so
+// videos indexed the old way are read again.
+const quoteVersion = 2
+
+// Quote is a line of dialogue from the subtitles of a video.
+type Quote struct {
+	Start    float64 `json:"start"`
+	End      float64 `json:"end"`
+	Text     string  `json:"text"`
+	Language string  `json:"language,omitempty"`
+}
+
+// QuoteFile is the indexed dialogue of one video, by path relative to its
+// library root.
+type QuoteFile struct {
+	Path   string
+	Quotes []Quote
+}
+
+type storedQuotes struct {
+	Version int       `json:"version"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	// Sources fingerprints the external subtitle files, which change
+	// without the video changing.
+	Sources string  `json:"sources,omitempty"`
+	Quotes  []Quote `json:"quotes"`
+}
+
+// subtitleSources fingerprints the external subtitles of a video.
+func subtitleSources(videoPath string) string {
+	hash := sha256.New()
+	for _, p := range media.SidecarSubtitles(videoPath) {
+		if info, err := os.Stat(p); err == nil {
+			fmt.Fprintf(hash, "%s\x00%d\x00%d\x00", filepath.Base(p), info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// IndexQuotes reads the dialogue of videos whose subtitles are not indexed
+// yet or changed since, and returns how many it read. Videos without
+// readable subtitles are stored with no dialogue, so they are not retried
+// until they change. Like probing, it waits for a pass already running.
+func (x *Index) IndexQuotes(ctx context.Context, library, root string) int {
+	x.reading.Lock()
+	defer x.reading.Unlock()
+	var videos []Entry
+	x.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(library))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, v []byte) error {
+			if e, err := decode(v); err == nil && e.Type == "video" {
+				videos = append(videos, e)
+			}
+			return nil
+		})
+	})
+
+	indexed := 0
+	for _, e := range videos {
+		if ctx.Err() != nil {
+			break
+		}
+		full := filepath.Join(root, filepath.FromSlash(e.Path))
+		stored := storedQuotes{Version: quoteVersion, Size: e.Size, ModTime: e.ModTime, Sources: subtitleSources(full)}
+		if x.quotesCurrent(full, stored) {
+			continue
+		}
+		stored.Quotes = readQuotes(full)
+		v, err := json.Marshal(stored)
+		if err != nil {
+			continue
+		}
+		x.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(quoteBucket).Put([]byte(full), v)
+		})
+		indexed++
+	}
+	return indexed
+}
+
+// quotesCurrent reports whether the dialogue stored for a video was read
+// from the version of it and its subtitles that want describes.
+func (x *Index) quotesCurrent(path string, want storedQuotes) bool {
+	current := false
+	x.db.View(func(tx *bolt.Tx) error {
+		var stored storedQuotes
+		v := tx.Bucket(quoteBucket).Get([]byte(path))
+		if v == nil || json.Unmarshal(v, &stored) != nil {
+			return nil
+		}
+		current = stored.Version == want.Version && stored.Size == want.Size &&
+			stored.ModTime.Equal(want.ModTime) && stored.Sources == want.Sources
+		return nil
+	})
+	return current
+}
+
+// readQuotes converts the dialogue tracks of a video to WebVTT, through the
+// subtitle cache players use too, and reads their lines in time order.
+func readQuotes(videoPath string) []Quote {
+	var quotes []Quote
+	for _, track := range media.DialogueTracks(videoPath) {
+		path, err := media.CachedSubtitle(videoPath, track)
+		if err != nil {
+			log.Printf("WARN [index] failed to read subtitles file=%s track=%s: %v", videoPath, track.ID(), err)
+			continue
+		}
+		cues, err := media.ReadVTTCues(path)
+		if err != nil {
+			continue
+		}
+		for _, c := range cues {
+			quotes = append(quotes, Quote{Start: c.Start, End: c.End, Text: c.Text, Language: track.Language})
+		}
+	}
+	sort.SliceStable(quotes, func(i, j int) bool { return quotes[i].Start < quotes[j].Start })
+	return quotes
+}
+
+// SearchQuotes returns the dialogue of the videos under root that contains
+// every one of words, lowercased, somewhere: a quick filter ahead of
+// matching lines one by one.
+func (x *Index) SearchQuotes(root string, words []string) []QuoteFile {
+	prefix := root
+	if !strings.HasSuffix(prefix, string(os.PathSeparator)) {
+		prefix += string(os.PathSeparator)
+	}
+	needles := make([][]byte, len(words))
+	for i, w := range words {
+		needles[i] = []byte(strings.ToLower(w))
+	}
+	var files []QuoteFile
+	x.db.View(func(tx *bolt.Tx) error {
+		return scanPrefix(tx.Bucket(quoteBucket), []byte(prefix), func(k, v []byte) error {
+			lower := bytes.ToLower(v)
+			for _, n := range needles {
+				if !bytes.Contains(lower, n) {
+					return nil
+				}
+			}
+			var stored storedQuotes
+			if json.Unmarshal(v, &stored) != nil || len(stored.Quotes) == 0 {
+				return nil
+			}
+			rel := filepath.ToSlash(strings.TrimPrefix(string(k), prefix))
+			files = append(files, QuoteFile{Path: rel, Quotes: stored.Quotes})
+			return nil
+		})
+	})
+	return files
+}
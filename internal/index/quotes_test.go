@@ -0,0 +1,62 @@
+package index
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestSearchQuotes(t *testing.T) {
+	x, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+
+	root := filepath.Join(t.TempDir(), "media")
+	stored := map[string][]Quote{
+		filepath.Join(root, "Show", "S01E01.mkv"):    {{Start: 62.5, Text: "I am the one who knocks"}},
+		filepath.Join(root, "Show", "S01E02.mkv"):    {{Start: 10, Text: "Knock knock"}, {Start: 20, Text: "Who's there?"}},
+		filepath.Join(root, "Movie.mkv"):             {{Start: 5, Text: "Say my name"}},
+		filepath.Join(root, "Empty.mkv"):             nil,
+		filepath.Join(root+"2", "Other.mkv"):         {{Start: 1, Text: "who knocks"}},
+		filepath.Join(root, "Show", "Unrelated.mkv"): {{Start: 1, Text: "nothing to see"}},
+	}
+	x.db.Update(func(tx *bolt.Tx) error {
+		for path, quotes := range stored {
+			v, _ := json.Marshal(storedQuotes{Version: quoteVersion, Quotes: quotes})
+			tx.Bucket(quoteBucket).Put([]byte(path), v)
+		}
+		return nil
+	})
+
+	tests := []struct {
+		name  string
+		words []string
+		want  []string
+	}{
+		{"one word", []string{"name"}, []string{"Movie.mkv"}},
+		{"case insensitive", []string{"KNOCK"}, []string{"Show/S01E01.mkv", "Show/S01E02.mkv"}},
+		{"every word", []string{"who", "knocks"}, []string{"Show/S01E01.mkv"}},
+		{"words across lines", []string{"knock", "there"}, []string{"Show/S01E02.mkv"}},
+		{"no match", []string{"heisenberg"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, f := range x.SearchQuotes(root, tt.words) {
+				got = append(got, f.Path)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("SearchQuotes(%v) = %v, want %v", tt.words, got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("SearchQuotes(%v) = %v, want %v", tt.words, got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,314 @@
+package index
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/tanq16/raikiri/internal/media"
+	"github.com/tanq16/raikiri/internal/video"
+)
+
+// Number of probe results written per transaction.
+const probeBatch = 32
+
+type ScanResult struct {
+	Entries int
+	Updated int
+	Removed int
+}
+
+// hidden reports whether rel or any of its parents is a dotfile; those are
+// left out of listings (thumbnails, subtitle caches and the like).
+func hidden(rel string) bool {
+	for part := range strings.SplitSeq(rel, "/") {
+		if strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadDisk lists dir inside root straight from the filesystem, skipping
+// hidden files and folders. It is what the index stores and what listings
+// fall back to before the first scan has finished.
+func ReadDisk(root, dir string, recursive bool) ([]Entry, error) {
+	full := filepath.Join(root, filepath.FromSlash(dir))
+	var entries []Entry
+	if !recursive {
+		files, err := os.ReadDir(full)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if strings.HasPrefix(f.Name(), ".") {
+				continue
+			}
+			info, err := f.Info()
+			if err != nil {
+				continue
+			}
+			entries = append(entries, NewEntry(path.Join(dir, f.Name()), info))
+		}
+		return entries, nil
+	}
+
+	if _, err := os.Stat(full); err != nil {
+		return nil, err
+	}
+	filepath.WalkDir(full, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == full {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, p)
+		entries = append(entries, NewEntry(filepath.ToSlash(rel), info))
+		return nil
+	})
+	return entries, nil
+}
+
+// Scan brings the whole library in line with the filesystem under root.
+func (x *Index) Scan(library, root string) (ScanResult, error) {
+	return x.scanDir(library, root, "")
+}
+
+// Rebuild discards everything known about library, including probe results,
+// and scans it again from scratch.
+func (x *Index) Rebuild(library, root string) (ScanResult, error) {
+	err := x.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(library)) == nil {
+			return nil
+		}
+		return tx.DeleteBucket([]byte(library))
+	})
+	if err != nil {
+		return ScanResult{}, fmt.Errorf("failed to clear index: %w", err)
+	}
+	return x.Scan(library, root)
+}
+
+// scanDir re-reads the subtree at dir and applies the differences. Probe
+// results are kept for files whose size and modification time are unchanged.
+func (x *Index) scanDir(library, root, dir string) (ScanResult, error) {
+	var result ScanResult
+	found, err := ReadDisk(root, dir, true)
+	if err != nil {
+		return result, err
+	}
+	result.Entries = len(found)
+
+	err = x.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(library))
+		if err != nil {
+			return err
+		}
+		stale := make(map[string]string) // key -> path
+		for _, prefix := range subtreePrefixes(dir) {
+			scanPrefix(b, prefix, func(k, v []byte) error {
+				e, _ := decode(v)
+				stale[string(k)] = e.Path
+				return nil
+			})
+		}
+		for _, e := range found {
+			k := key(e.Path)
+			delete(stale, string(k))
+			if v := b.Get(k); v != nil {
+				if old, err := decode(v); err == nil && old.sameFile(e) {
+					continue
+				}
+			}
+			if err := put(b, e); err != nil {
+				return err
+			}
+			result.Updated++
+		}
+		for k, rel := range stale {
+			if err := b.Delete([]byte(k)); err != nil {
+				return err
+			}
+			dropProbe(tx, root, rel)
+			result.Removed++
+		}
+		if dir != "" {
+			return nil
+		}
+		meta, err := json.Marshal(libraryMeta{Root: root, Scanned: time.Now()})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(metaBucket).Put([]byte(library), meta)
+	})
+	return result, err
+}
+
+// Update refreshes a single path after a filesystem event: it is removed
+// (with everything beneath it) when gone, rescanned when it is a folder and
+// re-read otherwise. The parent folder is refreshed too since its
+// modification time changes with its contents.
+func (x *Index) Update(library, root, rel string) error {
+	if rel == "" || rel == "." || hidden(rel) {
+		return nil
+	}
+	if parent := path.Dir(rel); parent != "." {
+		if err := x.updateEntry(library, root, parent); err != nil {
+			return err
+		}
+	}
+	if err := x.updateEntry(library, root, rel); err != nil {
+		return err
+	}
+	if e, ok := x.Get(library, rel); ok && e.IsDir() {
+		_, err := x.scanDir(library, root, rel)
+		return err
+	}
+	return nil
+}
+
+func (x *Index) updateEntry(library, root, rel string) error {
+	info, statErr := os.Stat(filepath.Join(root, filepath.FromSlash(rel)))
+	return x.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(library))
+		if err != nil {
+			return err
+		}
+		if statErr != nil {
+			if err := b.Delete(key(rel)); err != nil {
+				return err
+			}
+			dropProbe(tx, root, rel)
+			for _, prefix := range subtreePrefixes(rel) {
+				removed := make(map[string]string)
+				scanPrefix(b, prefix, func(k, v []byte) error {
+					e, _ := decode(v)
+					removed[string(k)] = e.Path
+					return nil
+				})
+				for k, p := range removed {
+					if err := b.Delete([]byte(k)); err != nil {
+						return err
+					}
+					dropProbe(tx, root, p)
+				}
+			}
+			return nil
+		}
+		e := NewEntry(rel, info)
+		if v := b.Get(key(rel)); v != nil {
+			if old, err := decode(v); err == nil && old.sameFile(e) {
+				e.Media = old.Media
+			}
+		}
+		return put(b, e)
+	})
+}
+
+func mediaInfo(data *video.FFProbeOutput) *MediaInfo {
+	info := &MediaInfo{Container: data.Format.FormatName}
+	info.Duration, _ = strconv.ParseFloat(data.Format.Duration, 64)
+	for _, s := range data.Streams {
+		switch s.CodecType {
+		case "video":
+			if info.VideoCodec == "" {
+				info.VideoCodec = s.CodecName
+				info.Width = s.Width
+				info.Height = s.Height
+			}
+		case "audio":
+			info.AudioCodecs = append(info.AudioCodecs, s.CodecName)
+		case "subtitle":
+			info.Subtitles++
+		}
+	}
+	return info
+}
+
+// ProbePending runs ffprobe on audio and video entries that have no media
+// facts yet and returns how many were probed. Failures are recorded so the
+// same file is not retried until it changes. A pass already running, from
+// a rescan or the watcher, is waited for first.
+func (x *Index) ProbePending(ctx context.Context, library, root string) int {
+	x.reading.Lock()
+	defer x.reading.Unlock()
+	var pending []Entry
+	x.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(library))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, v []byte) error {
+			if e, err := decode(v); err == nil && e.probeable() && e.Media == nil {
+				pending = append(pending, e)
+			}
+			return nil
+		})
+	})
+
+	probed := 0
+	var batch []Entry
+	for _, e := range pending {
+		if ctx.Err() != nil {
+			break
+		}
+		data, err := media.Probe(filepath.Join(root, filepath.FromSlash(e.Path)))
+		if err != nil {
+			e.Media = &MediaInfo{Error: err.Error()}
+		} else {
+			e.Media = mediaInfo(data)
+		}
+		batch = append(batch, e)
+		probed++
+		if len(batch) >= probeBatch {
+			x.storeProbes(library, batch)
+			batch = batch[:0]
+		}
+	}
+	x.storeProbes(library, batch)
+	return probed
+}
+
+// storeProbes saves probe results for entries that did not change while
+// they were being probed.
+func (x *Index) storeProbes(library string, batch []Entry) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	return x.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(library))
+		if b == nil {
+			return nil
+		}
+		for _, e := range batch {
+			v := b.Get(key(e.Path))
+			if v == nil {
+				continue
+			}
+			if old, err := decode(v); err != nil || !old.sameFile(e) {
+				continue
+			}
+			if err := put(b, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
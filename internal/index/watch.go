@@ -0,0 +1,165 @@
+package index
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Filesystem events are collected for this long before the index is updated,
+// so a large copy settles into a handful of updates.
+const watchDebounce = 2 * time.Second
+
+// Run keeps the index in sync with the libraries until ctx is cancelled: a
+// watcher applies filesystem events as they happen and a full rescan runs at
+// startup and then every interval (never when interval is zero) to catch
+// anything the watcher missed, such as changes made on network shares. With
+// probe set, new audio and video files are ffprobed after each scan, and the
+// subtitles of new videos are read for dialogue search once every library
+// is scanned, as that takes far longer. Files the watcher picks up are
+// probed and read the same way once their changes are applied.
+func (x *Index) Run(ctx context.Context, libraries []Library, interval time.Duration, probe bool) {
+	for _, lib := range libraries {
+		go x.watch(ctx, lib, probe)
+	}
+	for {
+		for _, lib := range libraries {
+			start := time.Now()
+			result, err := x.Scan(lib.Name, lib.Root)
+			if err != nil {
+				log.Printf("ERROR [index] scan failed library=%s root=%s: %v", lib.Name, lib.Root, err)
+				continue
+			}
+			log.Printf("INFO [index] scanned library=%s entries=%d updated=%d removed=%d took=%s", lib.Name, result.Entries, result.Updated, result.Removed, time.Since(start).Round(time.Millisecond))
+			if probe {
+				if n := x.ProbePending(ctx, lib.Name, lib.Root); n > 0 {
+					log.Printf("INFO [index] probed library=%s files=%d", lib.Name, n)
+				}
+			}
+		}
+		if probe {
+			for _, lib := range libraries {
+				if n := x.IndexQuotes(ctx, lib.Name, lib.Root); n > 0 {
+					log.Printf("INFO [index] indexed subtitles library=%s videos=%d", lib.Name, n)
+				}
+			}
+		}
+		if interval <= 0 {
+			<-ctx.Done()
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (x *Index) watch(ctx context.Context, lib Library, probe bool) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("WARN [index] file watching unavailable library=%s, relying on periodic rescans: %v", lib.Name, err)
+		return
+	}
+	defer w.Close()
+	addWatches(w, lib, lib.Root)
+
+	// Probing and reading subtitles run apart from the watcher, so events
+	// keep being drained meanwhile; changes made while they run queue one
+	// more pass.
+	changed := make(chan struct{}, 1)
+	if probe {
+		go x.readChanged(ctx, lib, changed)
+	}
+
+	dirty := make(map[string]bool)
+	ticker := time.NewTicker(watchDebounce)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if ev.Op == fsnotify.Chmod {
+				continue
+			}
+			rel, err := filepath.Rel(lib.Root, ev.Name)
+			if err != nil {
+				continue
+			}
+			rel = filepath.ToSlash(rel)
+			if rel == "." || hidden(rel) {
+				continue
+			}
+			if ev.Has(fsnotify.Create) {
+				if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+					addWatches(w, lib, ev.Name)
+				}
+			}
+			dirty[rel] = true
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("WARN [index] watcher error library=%s: %v", lib.Name, err)
+		case <-ticker.C:
+			for rel := range dirty {
+				if err := x.Update(lib.Name, lib.Root, rel); err != nil {
+					log.Printf("ERROR [index] update failed library=%s path=%s: %v", lib.Name, rel, err)
+				}
+			}
+			if probe && len(dirty) > 0 {
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			}
+			clear(dirty)
+		}
+	}
+}
+
+// readChanged probes the new files of a library and reads the subtitles of
+// its new videos whenever the watcher signals changes.
+func (x *Index) readChanged(ctx context.Context, lib Library, changed <-chan struct{}) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		}
+		if n := x.ProbePending(ctx, lib.Name, lib.Root); n > 0 {
+			log.Printf("INFO [index] probed library=%s files=%d", lib.Name, n)
+		}
+		if n := x.IndexQuotes(ctx, lib.Name, lib.Root); n > 0 {
+			log.Printf("INFO [index] indexed subtitles library=%s videos=%d", lib.Name, n)
+		}
+	}
+}
+
+// addWatches watches dir and every folder beneath it. fsnotify is not
+// recursive, so new folders are added as they appear.
+func addWatches(w *fsnotify.Watcher, lib Library, dir string) {
+	filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if p != lib.Root && d.Name()[0] == '.' {
+			return filepath.SkipDir
+		}
+		if err := w.Add(p); err != nil {
+			log.Printf("WARN [index] cannot watch library=%s path=%s, relying on periodic rescans: %v", lib.Name, p, err)
+			return filepath.SkipAll
+		}
+		return nil
+	})
+}
@@ -2,71 +2,101 @@ package media
 
 import (
 	"fmt"
-	"os/exec"
 	"slices"
 	"strconv"
 	"strings"
+
+	"github.com/tanq16/raikiri/internal/video"
 )
 
 func GetVideoDuration(filePath string) (float64, error) {
-	cmd := exec.Command("ffprobe", "-v", "error", "-show_entries", "format=duration", "-of", "default=noprint_wrappers=1:nokey=1", filePath)
-	output, err := cmd.Output()
+	data, err := Probe(filePath)
 	if err != nil {
 		return 0, fmt.Errorf("failed to get video duration: %w", err)
 	}
-	durationStr := strings.TrimSpace(string(output))
-	duration, err := strconv.ParseFloat(durationStr, 64)
+	duration, err := strconv.ParseFloat(data.Format.Duration, 64)
 	if err != nil {
 		return 0, fmt.Errorf("failed to parse duration: %w", err)
 	}
 	return duration, nil
 }
 
-func GetAudioTracks(filePath string) []AudioTrack {
-	cmd := exec.Command("ffprobe",
-		"-v", "error",
-		"-select_streams", "a",
-		"-show_entries", "stream=index,codec_name,profile,channels:stream_tags=language",
-		"-of", "csv=p=0",
-		filePath)
+// GetStartTime returns the timestamp the file starts at, which ffmpeg
+// subtracts from seek positions; most containers start at zero.
+func GetStartTime(filePath string) float64 {
+	data, err := Probe(filePath)
+	if err != nil {
+		return 0
+	}
+	start, _ := strconv.ParseFloat(data.Format.StartTime, 64)
+	return start
+}
 
-	output, err := cmd.Output()
+// GetBitrate returns the overall bitrate of a file in bits per second,
+// estimated from its size when the container does not say.
+func GetBitrate(filePath string) int {
+	data, err := Probe(filePath)
+	if err != nil {
+		return 0
+	}
+	if rate, err := strconv.Atoi(data.Format.BitRate); err == nil && rate > 0 {
+		return rate
+	}
+	size, _ := strconv.ParseFloat(data.Format.Size, 64)
+	duration, _ := strconv.ParseFloat(data.Format.Duration, 64)
+	if size <= 0 || duration <= 0 {
+		return 0
+	}
+	return int(size * 8 / duration)
+}
+
+// GetVideoDimensions returns the width and height of the first video
+// stream, or zeros when unknown.
+func GetVideoDimensions(filePath string) (width, height int) {
+	for _, s := range streams(filePath, "video") {
+		return s.Width, s.Height
+	}
+	return 0, 0
+}
+
+// streams returns the streams of one codec type ("video", "audio",
+// "subtitle") in file order, or nil if the file cannot be probed.
+func streams(filePath, codecType string) []video.Stream {
+	data, err := Probe(filePath)
 	if err != nil {
 		return nil
 	}
+	var matched []video.Stream
+	for _, s := range data.Streams {
+		if s.CodecType == codecType {
+			matched = append(matched, s)
+		}
+	}
+	return matched
+}
 
+func GetAudioTracks(filePath string) []AudioTrack {
 	var tracks []AudioTrack
-	lines := strings.SplitSeq(strings.TrimSpace(string(output)), "\n")
-	for line := range lines {
-		if line == "" {
-			continue
+	for _, s := range streams(filePath, "audio") {
+		channels := s.Channels
+		if channels == 0 {
+			channels = 2
 		}
-		parts := strings.Split(line, ",")
-		if len(parts) >= 4 {
-			index, err := strconv.Atoi(parts[0])
-			if err != nil {
-				continue
-			}
-			codec := parts[1]
-			profile := parts[2]
-			channels, err := strconv.Atoi(parts[3])
-			if err != nil {
-				channels = 2
-			}
-			language := "und"
-			if len(parts) >= 5 {
-				language = parts[4]
-			}
-			tracks = append(tracks, AudioTrack{
-				Index:    index,
-				Codec:    codec,
-				Profile:  profile,
-				Language: language,
-				Channels: channels,
-			})
+		language := s.Tags.Language
+		if language == "" {
+			language = "und"
 		}
+		track := AudioTrack{
+			Index:    s.Index,
+			Codec:    s.CodecName,
+			Profile:  s.Profile,
+			Language: language,
+			Title:    s.Tags.Title,
+			Channels: channels,
+		}
+		track.Label = audioLabel(track)
+		tracks = append(tracks, track)
 	}
-
 	return tracks
 }
 
@@ -83,12 +113,11 @@ func SelectBestAudioTrack(tracks []AudioTrack) *AudioTrack {
 }
 
 func GetVideoCodec(filePath string) string {
-	cmd := exec.Command("ffprobe", "-v", "error", "-select_streams", "v:0", "-show_entries", "stream=codec_name", "-of", "default=noprint_wrappers=1:nokey=1", filePath)
-	output, err := cmd.Output()
-	if err != nil {
+	videos := streams(filePath, "video")
+	if len(videos) == 0 {
 		return ""
 	}
-	return strings.TrimSpace(string(output))
+	return videos[0].CodecName
 }
 
 func IsAudioCompatible(codec string) bool {
@@ -102,60 +131,32 @@ func IsVideoCompatibleForHLS(codec string) bool {
 }
 
 func GetContainerFormat(filePath string) string {
-	cmd := exec.Command("ffprobe", "-v", "error", "-show_entries", "format=format_name", "-of", "default=noprint_wrappers=1:nokey=1", filePath)
-	output, err := cmd.Output()
+	data, err := Probe(filePath)
 	if err != nil {
 		return ""
 	}
-	return strings.TrimSpace(string(output))
+	return data.Format.FormatName
 }
 
 func GetAudioSampleRate(filePath string, streamIndex int) int {
-	cmd := exec.Command("ffprobe", "-v", "error",
-		"-select_streams", fmt.Sprintf("%d", streamIndex),
-		"-show_entries", "stream=sample_rate",
-		"-of", "default=noprint_wrappers=1:nokey=1",
-		filePath)
-	output, err := cmd.Output()
+	data, err := Probe(filePath)
 	if err != nil {
 		return 0
 	}
-	rate, err := strconv.Atoi(strings.TrimSpace(string(output)))
-	if err != nil {
-		return 0
+	for _, s := range data.Streams {
+		if s.Index == streamIndex {
+			rate, err := strconv.Atoi(strings.TrimSpace(s.SampleRate))
+			if err != nil {
+				return 0
+			}
+			return rate
+		}
 	}
-	return rate
+	return 0
 }
 
-// Requires: MP4/MOV container, HLS-compatible video, compatible audio, stereo, 48kHz.
+// IsDirectServable reports whether a client without a device profile plays
+// the file as is; see DefaultProfile.
 func IsDirectServable(filePath string) bool {
-	format := GetContainerFormat(filePath)
-	if !strings.Contains(format, "mp4") && !strings.Contains(format, "mov") {
-		return false
-	}
-
-	videoCodec := GetVideoCodec(filePath)
-	if !IsVideoCompatibleForHLS(videoCodec) {
-		return false
-	}
-
-	tracks := GetAudioTracks(filePath)
-	selected := SelectBestAudioTrack(tracks)
-	if selected == nil {
-		return true // no audio is fine
-	}
-
-	if !IsAudioCompatible(selected.Codec) {
-		return false
-	}
-	if selected.Channels > 2 {
-		return false
-	}
-
-	sampleRate := GetAudioSampleRate(filePath, selected.Index)
-	if sampleRate != 48000 {
-		return false
-	}
-
-	return true
+	return DefaultProfile().CanDirectPlay(filePath, SelectBestAudioTrack(GetAudioTracks(filePath)))
 }
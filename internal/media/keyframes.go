@@ -0,0 +1,179 @@
+package media
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeyframeStore persists keyframe lists across restarts, with the same
+// version rules as ProbeStore.
+type KeyframeStore interface {
+	LoadKeyframes(path string, size int64, modTime time.Time) ([]float64, bool)
+	SaveKeyframes(path string, size int64, modTime time.Time, keyframes []float64)
+}
+
+type keyframeJob struct {
+	done      chan struct{}
+	keyframes []float64
+	err       error
+}
+
+type keyframeItem struct {
+	key       probeKey
+	keyframes []float64
+}
+
+// keyframeCache remembers the keyframe lists of the probeCacheSize files
+// used last, by file version, and makes concurrent requests for the same
+// file share one ffprobe run, which reads the whole file and can take a
+// while on large videos.
+var keyframeCache = struct {
+	mu      sync.Mutex
+	order   *list.List
+	known   map[string]*list.Element
+	running map[probeKey]*keyframeJob
+	store   KeyframeStore
+}{
+	order:   list.New(),
+	known:   make(map[string]*list.Element),
+	running: make(map[probeKey]*keyframeJob),
+}
+
+// knownKeyframes returns the remembered keyframes of a file version;
+// keyframeCache.mu must be held.
+func knownKeyframes(key probeKey) ([]float64, bool) {
+	c := &keyframeCache
+	el, ok := c.known[key.path]
+	if !ok {
+		return nil, false
+	}
+	item := el.Value.(*keyframeItem)
+	if item.key.size != key.size || !item.key.modTime.Equal(key.modTime) {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return item.keyframes, true
+}
+
+// rememberKeyframes records the keyframes of a file version, forgetting the
+// least recently used file past probeCacheSize; keyframeCache.mu must be
+// held.
+func rememberKeyframes(key probeKey, keyframes []float64) {
+	c := &keyframeCache
+	if el, ok := c.known[key.path]; ok {
+		c.order.Remove(el)
+	}
+	c.known[key.path] = c.order.PushFront(&keyframeItem{key: key, keyframes: keyframes})
+	for c.order.Len() > probeCacheSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.known, oldest.Value.(*keyframeItem).key.path)
+	}
+}
+
+// SetKeyframeStore makes keyframe lists survive restarts. Pass nil to keep
+// them in memory only.
+func SetKeyframeStore(store KeyframeStore) {
+	keyframeCache.mu.Lock()
+	defer keyframeCache.mu.Unlock()
+	keyframeCache.store = store
+}
+
+// Keyframes returns the sorted presentation times, in seconds, of the
+// keyframes of the first video stream, waiting at most timeout for ffprobe.
+// When it runs out of time the lookup carries on in the background, so a
+// later call finds the result; ok is false until then.
+func Keyframes(filePath string, timeout time.Duration) (keyframes []float64, ok bool) {
+	abs, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, false
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return nil, false
+	}
+	key := probeKey{path: abs, size: info.Size(), modTime: info.ModTime()}
+
+	c := &keyframeCache
+	c.mu.Lock()
+	if kf, ok := knownKeyframes(key); ok {
+		c.mu.Unlock()
+		return kf, true
+	}
+	store := c.store
+	job, running := c.running[key]
+	if !running {
+		if store != nil {
+			if kf, ok := store.LoadKeyframes(abs, key.size, key.modTime); ok {
+				rememberKeyframes(key, kf)
+				c.mu.Unlock()
+				return kf, true
+			}
+		}
+		job = &keyframeJob{done: make(chan struct{})}
+		c.running[key] = job
+		go func() {
+			job.keyframes, job.err = probeKeyframes(abs)
+			c.mu.Lock()
+			delete(c.running, key)
+			if job.err == nil {
+				rememberKeyframes(key, job.keyframes)
+			}
+			c.mu.Unlock()
+			if job.err == nil && store != nil {
+				store.SaveKeyframes(abs, key.size, key.modTime, job.keyframes)
+			}
+			close(job.done)
+		}()
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-job.done:
+		return job.keyframes, job.err == nil
+	case <-time.After(timeout):
+		return nil, false
+	}
+}
+
+// probeKeyframes lists keyframe packets with ffprobe. Packets come in decode
+// order, so the times are sorted afterwards.
+func probeKeyframes(filePath string) ([]float64, error) {
+	cmd := exec.Command("ffprobe",
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "packet=pts_time,flags",
+		"-of", "csv=p=0",
+		filePath,
+	)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keyframes: %w", err)
+	}
+	var keyframes []float64
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		ptsTime, flags, ok := strings.Cut(sc.Text(), ",")
+		if !ok || !strings.Contains(flags, "K") {
+			continue
+		}
+		if t, err := strconv.ParseFloat(ptsTime, 64); err == nil {
+			keyframes = append(keyframes, t)
+		}
+	}
+	if len(keyframes) == 0 {
+		return nil, fmt.Errorf("no keyframes found")
+	}
+	sort.Float64s(keyframes)
+	return keyframes, nil
+}
@@ -0,0 +1,38 @@
+package media
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestKeyframeCacheBounded(t *testing.T) {
+	modTime := time.Unix(1700000000, 0)
+	key := func(i int) probeKey {
+		return probeKey{path: fmt.Sprintf("/media/%d.mkv", i), size: 1, modTime: modTime}
+	}
+	keyframeCache.mu.Lock()
+	defer keyframeCache.mu.Unlock()
+	for i := range probeCacheSize + 10 {
+		rememberKeyframes(key(i), []float64{0, float64(i)})
+		// Using a file keeps it.
+		knownKeyframes(key(0))
+	}
+	if n := keyframeCache.order.Len(); n != probeCacheSize || len(keyframeCache.known) != probeCacheSize {
+		t.Fatalf("cache holds %d/%d files, want %d", n, len(keyframeCache.known), probeCacheSize)
+	}
+	if _, ok := knownKeyframes(key(0)); !ok {
+		t.Error("recently used file evicted")
+	}
+	if _, ok := knownKeyframes(key(1)); ok {
+		t.Error("least recently used file kept")
+	}
+	if kf, ok := knownKeyframes(key(probeCacheSize + 9)); !ok || kf[1] != float64(probeCacheSize+9) {
+		t.Errorf("knownKeyframes(newest) = %v, %v", kf, ok)
+	}
+	changed := key(probeCacheSize + 9)
+	changed.size = 2
+	if _, ok := knownKeyframes(changed); ok {
+		t.Error("keyframes of an older version of the file returned")
+	}
+}
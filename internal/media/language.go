@@ -0,0 +1,77 @@
+package media
+
+import (
+	"fmt"
+	"strings"
+)
+
+// languageNames maps the ISO 639 codes found in stream tags and file names
+// (two-letter and both three-letter forms) to display names.
+var languageNames = map[string]string{
+	"ar": "Arabic", "ara": "Arabic",
+	"zh": "Chinese", "zho": "Chinese", "chi": "Chinese",
+	"cs": "Czech", "ces": "Czech", "cze": "Czech",
+	"da": "Danish", "dan": "Danish",
+	"nl": "Dutch", "nld": "Dutch", "dut": "Dutch",
+	"en": "English", "eng": "English",
+	"fi": "Finnish", "fin": "Finnish",
+	"fr": "French", "fra": "French", "fre": "French",
+	"de": "German", "deu": "German", "ger": "German",
+	"el": "Greek", "ell": "Greek", "gre": "Greek",
+	"he": "Hebrew", "heb": "Hebrew",
+	"hi": "Hindi", "hin": "Hindi",
+	"hu": "Hungarian", "hun": "Hungarian",
+	"id": "Indonesian", "ind": "Indonesian",
+	"it": "Italian", "ita": "Italian",
+	"ja": "Japanese", "jpn": "Japanese",
+	"ko": "Korean", "kor": "Korean",
+	"no": "Norwegian", "nor": "Norwegian", "nb": "Norwegian", "nob": "Norwegian",
+	"pl": "Polish", "pol": "Polish",
+	"pt": "Portuguese", "por": "Portuguese",
+	"ro": "Romanian", "ron": "Romanian", "rum": "Romanian",
+	"ru": "Russian", "rus": "Russian",
+	"es": "Spanish", "spa": "Spanish",
+	"sv": "Swedish", "swe": "Swedish",
+	"th": "Thai", "tha": "Thai",
+	"tr": "Turkish", "tur": "Turkish",
+	"uk": "Ukrainian", "ukr": "Ukrainian",
+	"vi": "Vietnamese", "vie": "Vietnamese",
+}
+
+// LanguageName returns the display name of a language code, or "" when
+// the code is unknown or undetermined.
+func LanguageName(code string) string {
+	return languageNames[strings.ToLower(code)]
+}
+
+// languageCode returns a code for a language written out in full, as in
+// "English", or "" when there is none.
+func languageCode(name string) string {
+	for code, n := range languageNames {
+		// Every language in the table has a two-letter code.
+		if len(code) == 2 && strings.EqualFold(n, name) {
+			return code
+		}
+	}
+	return ""
+}
+
+// audioLabel names an audio track for track menus: its title when it has
+// one, otherwise its language, with the channel layout.
+func audioLabel(t AudioTrack) string {
+	name := t.Title
+	if name == "" {
+		name = LanguageName(t.Language)
+	}
+	if name == "" {
+		name = fmt.Sprintf("Track %d", t.Index)
+	}
+	switch t.Channels {
+	case 1:
+		return name + " (Mono)"
+	case 2:
+		return name + " (Stereo)"
+	default:
+		return fmt.Sprintf("%s (%d.1)", name, t.Channels-1)
+	}
+}
@@ -0,0 +1,96 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Optimized copies are capped at this height, which old devices decode.
+const optimizedMaxHeight = 1080
+
+// OptimizedPath is where the optimized copy of a video lives: a hidden
+// faststart MP4 next to it, like thumbnails.
+func OptimizedPath(filePath string) string {
+	return filepath.Join(filepath.Dir(filePath), fmt.Sprintf(".%s.optimized.mp4", filepath.Base(filePath)))
+}
+
+// FindOptimized returns the optimized copy of a video if there is one at
+// least as new as the video itself.
+func FindOptimized(filePath string) (string, bool) {
+	src, err := os.Stat(filePath)
+	if err != nil {
+		return "", false
+	}
+	optimized := OptimizedPath(filePath)
+	info, err := os.Stat(optimized)
+	if err != nil || info.Size() == 0 || info.ModTime().Before(src.ModTime()) {
+		return "", false
+	}
+	return optimized, true
+}
+
+// NeedsOptimize reports whether a video is worth an optimized copy: one
+// clients without a device profile cannot play as it is.
+func NeedsOptimize(filePath string) bool {
+	return !IsDirectServable(filePath)
+}
+
+// optimizeArgs builds the ffmpeg command that writes an MP4 every client
+// direct plays: H.264 of at most 1080p in SDR and the best audio track as
+// AAC stereo at 48kHz, with the index up front for quick starts. Streams
+// that already fit are copied.
+func optimizeArgs(filePath, output string) []string {
+	args := []string{"-i", filePath, "-map", "0:v:0"}
+	_, height := GetVideoDimensions(filePath)
+	hdr := IsHDR(filePath)
+	if GetVideoCodec(filePath) == "h264" && !hdr && height <= optimizedMaxHeight {
+		args = append(args, "-c:v", "copy")
+	} else {
+		var filters []string
+		if height > optimizedMaxHeight {
+			filters = append(filters, fmt.Sprintf("scale=-2:%d", optimizedMaxHeight))
+		}
+		if hdr {
+			filters = append(filters, TonemapFilter)
+		} else {
+			filters = append(filters, "format=yuv420p")
+		}
+		args = append(args,
+			"-vf", strings.Join(filters, ","),
+			"-c:v", "libx264", "-preset", "medium", "-crf", "21",
+			"-profile:v", "high", "-level", "4.1",
+		)
+	}
+	if audio := SelectBestAudioTrack(GetAudioTracks(filePath)); audio != nil {
+		args = append(args, "-map", fmt.Sprintf("0:%d", audio.Index))
+		if DefaultProfile().CanPlayAudio(audio) && audio.Codec == "aac" && GetAudioSampleRate(filePath, audio.Index) == 48000 {
+			args = append(args, "-c:a", "copy")
+		} else {
+			args = append(args, "-c:a", "aac", "-b:a", "192k", "-ac", "2", "-ar", "48000")
+		}
+	}
+	return append(args, "-movflags", "+faststart", "-f", "mp4", "-y", output)
+}
+
+// Optimize writes the optimized copy of a video. The copy is written under
+// a temporary name and only takes the final one once complete, so the
+// server never picks up a partial file.
+func Optimize(ctx context.Context, filePath string) error {
+	output := OptimizedPath(filePath)
+	partial := output + ".part"
+	cmd := exec.CommandContext(ctx, "ffmpeg", append([]string{"-loglevel", "error"}, optimizeArgs(filePath, partial)...)...)
+	var stderrBuf strings.Builder
+	cmd.Stderr = &stderrBuf
+	if err := cmd.Run(); err != nil {
+		os.Remove(partial)
+		if stderrContent := strings.TrimSpace(stderrBuf.String()); stderrContent != "" {
+			return fmt.Errorf("%s: %w", stderrContent, err)
+		}
+		return err
+	}
+	return os.Rename(partial, output)
+}
@@ -0,0 +1,127 @@
+package media
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/tanq16/raikiri/internal/video"
+)
+
+// Number of files whose probe results are kept in memory.
+const probeCacheSize = 1024
+
+// ProbeStore persists probe results across restarts. Implementations must
+// only return a result recorded for the same size and modification time.
+type ProbeStore interface {
+	LoadProbe(path string, size int64, modTime time.Time) (*video.FFProbeOutput, bool)
+	SaveProbe(path string, size int64, modTime time.Time, data *video.FFProbeOutput)
+}
+
+type probeKey struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+type probeItem struct {
+	key  probeKey
+	data *video.FFProbeOutput
+}
+
+// probeCache is a small LRU of ffprobe results keyed by file version.
+type probeCache struct {
+	mu    sync.Mutex
+	order *list.List
+	items map[string]*list.Element
+	store ProbeStore
+}
+
+var probes = &probeCache{
+	order: list.New(),
+	items: make(map[string]*list.Element),
+}
+
+// SetProbeStore makes Probe persist its results, so files are not probed
+// again after a restart. Pass nil to keep results in memory only.
+func SetProbeStore(store ProbeStore) {
+	probes.mu.Lock()
+	defer probes.mu.Unlock()
+	probes.store = store
+}
+
+func (c *probeCache) get(key probeKey) (*video.FFProbeOutput, bool) {
+	c.mu.Lock()
+	if el, ok := c.items[key.path]; ok {
+		item := el.Value.(*probeItem)
+		if item.key.size == key.size && item.key.modTime.Equal(key.modTime) {
+			c.order.MoveToFront(el)
+			c.mu.Unlock()
+			return item.data, true
+		}
+	}
+	store := c.store
+	c.mu.Unlock()
+
+	if store == nil {
+		return nil, false
+	}
+	data, ok := store.LoadProbe(key.path, key.size, key.modTime)
+	if ok {
+		c.mu.Lock()
+		c.add(key, data)
+		c.mu.Unlock()
+	}
+	return data, ok
+}
+
+// add inserts or replaces the result for a path; c.mu must be held.
+func (c *probeCache) add(key probeKey, data *video.FFProbeOutput) {
+	if el, ok := c.items[key.path]; ok {
+		c.order.Remove(el)
+	}
+	c.items[key.path] = c.order.PushFront(&probeItem{key: key, data: data})
+	for c.order.Len() > probeCacheSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*probeItem).key.path)
+	}
+}
+
+func (c *probeCache) put(key probeKey, data *video.FFProbeOutput) {
+	c.mu.Lock()
+	c.add(key, data)
+	store := c.store
+	c.mu.Unlock()
+	if store != nil {
+		store.SaveProbe(key.path, key.size, key.modTime, data)
+	}
+}
+
+// Probe returns the format and streams of a file from a single ffprobe run.
+// Results are remembered per path, size and modification time, so callers can
+// ask for whatever details they need without spawning ffprobe again. The
+// returned value is shared and must not be modified.
+func Probe(filePath string) (*video.FFProbeOutput, error) {
+	abs, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	key := probeKey{path: abs, size: info.Size(), modTime: info.ModTime()}
+	if data, ok := probes.get(key); ok {
+		return data, nil
+	}
+	data, err := video.ProbeFile(abs)
+	if err != nil {
+		return nil, err
+	}
+	probes.put(key, data)
+	return data, nil
+}
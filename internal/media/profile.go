@@ -0,0 +1,168 @@
+package media
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// DeviceProfile describes what a client plays natively. Containers are
+// "mp4", "mov", "mkv", "webm", "ts" or "avi"; codecs use ffprobe names such
+// as "h264", "hevc", "vp9", "av1", "aac", "ac3", "eac3", "opus" or "flac".
+type DeviceProfile struct {
+	Name             string   `json:"name,omitempty"`
+	Containers       []string `json:"containers"`
+	VideoCodecs      []string `json:"videoCodecs"`
+	AudioCodecs      []string `json:"audioCodecs"`
+	MaxAudioChannels int      `json:"maxAudioChannels,omitempty"`
+	// AudioSampleRates limits direct play to these rates; empty allows any.
+	AudioSampleRates []int `json:"audioSampleRates,omitempty"`
+	// HDR tells whether the client displays HDR; nil when it did not say,
+	// in which case HDR video is passed through as it is.
+	HDR *bool `json:"hdr,omitempty"`
+}
+
+// DisplaysHDR reports whether HDR video can be sent to the client as it
+// is. Only clients that say they cannot display HDR get it tone mapped.
+func (p *DeviceProfile) DisplaysHDR() bool {
+	return p.HDR == nil || *p.HDR
+}
+
+// DefaultProfile is assumed for clients that do not send a profile: a
+// browser playing MP4/MOV with H.264 or HEVC and stereo 48kHz audio. Whether
+// it displays HDR is unknown.
+func DefaultProfile() *DeviceProfile {
+	return &DeviceProfile{
+		Name:             "default",
+		Containers:       []string{"mp4", "mov"},
+		VideoCodecs:      []string{"h264", "hevc"},
+		AudioCodecs:      []string{"aac", "mp3", "opus"},
+		MaxAudioChannels: 2,
+		AudioSampleRates: []int{48000},
+	}
+}
+
+var codecAliases = map[string]string{
+	"avc":      "h264",
+	"avc1":     "h264",
+	"h265":     "hevc",
+	"hvc1":     "hevc",
+	"av01":     "av1",
+	"vp09":     "vp9",
+	"matroska": "mkv",
+	"m4v":      "mp4",
+	"mpegts":   "ts",
+	"ec-3":     "eac3",
+	"ac-3":     "ac3",
+}
+
+func normalizeName(n string) string {
+	n = strings.ToLower(strings.TrimSpace(n))
+	if alias, ok := codecAliases[n]; ok {
+		return alias
+	}
+	return n
+}
+
+func normalizeNames(names []string) []string {
+	out := make([]string, 0, len(names))
+	for _, n := range names {
+		n = normalizeName(n)
+		if n != "" && !slices.Contains(out, n) {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// Normalize lowercases names and maps common aliases (avc, h265, matroska)
+// to the names used here. Clients that leave out the channel count are
+// taken to be stereo.
+func (p *DeviceProfile) Normalize() {
+	p.Containers = normalizeNames(p.Containers)
+	p.VideoCodecs = normalizeNames(p.VideoCodecs)
+	p.AudioCodecs = normalizeNames(p.AudioCodecs)
+	if p.MaxAudioChannels <= 0 {
+		p.MaxAudioChannels = 2
+	}
+}
+
+// CanPlayAudio reports whether the client decodes track as it is.
+func (p *DeviceProfile) CanPlayAudio(track *AudioTrack) bool {
+	return slices.Contains(p.AudioCodecs, track.Codec) && track.Channels <= p.MaxAudioChannels
+}
+
+// CanCopyVideo reports whether video in codec can be passed through to the
+// client inside HLS. HDR video is not copied to clients that cannot display
+// it.
+func (p *DeviceProfile) CanCopyVideo(codec string, hdr bool) bool {
+	return IsVideoCompatibleForHLS(codec) && slices.Contains(p.VideoCodecs, normalizeName(codec)) && (p.DisplaysHDR() || !hdr)
+}
+
+// CanCopyAudio reports whether track can be passed through inside fMP4 HLS,
+// which carries AAC and Dolby Digital.
+func (p *DeviceProfile) CanCopyAudio(track *AudioTrack) bool {
+	return slices.Contains([]string{"aac", "ac3", "eac3"}, track.Codec) && p.CanPlayAudio(track)
+}
+
+// CanDirectPlay reports whether the client plays the file untouched with
+// audio as its audio track.
+func (p *DeviceProfile) CanDirectPlay(filePath string, audio *AudioTrack) bool {
+	if !slices.Contains(p.Containers, GetContainer(filePath)) {
+		return false
+	}
+	if codec := GetVideoCodec(filePath); codec != "" {
+		if !slices.Contains(p.VideoCodecs, normalizeName(codec)) {
+			return false
+		}
+		if !p.DisplaysHDR() && IsHDR(filePath) {
+			return false
+		}
+	}
+	if audio == nil {
+		return true // no audio is fine
+	}
+	if !p.CanPlayAudio(audio) {
+		return false
+	}
+	if len(p.AudioSampleRates) > 0 && !slices.Contains(p.AudioSampleRates, GetAudioSampleRate(filePath, audio.Index)) {
+		return false
+	}
+	return true
+}
+
+// GetContainer names the container of a file the way profiles do. ffprobe
+// reports MP4 and MOV, and Matroska and WebM, under one format each, so the
+// extension tells them apart.
+func GetContainer(filePath string) string {
+	format := GetContainerFormat(filePath)
+	ext := strings.ToLower(filepath.Ext(filePath))
+	switch {
+	case strings.Contains(format, "matroska"):
+		if ext == ".webm" {
+			return "webm"
+		}
+		return "mkv"
+	case strings.Contains(format, "mp4"):
+		if ext == ".mov" {
+			return "mov"
+		}
+		return "mp4"
+	case format == "":
+		return ""
+	}
+	name, _, _ := strings.Cut(format, ",")
+	return normalizeName(name)
+}
+
+// TonemapFilter converts HDR video to SDR BT.709 for clients that cannot
+// display HDR.
+const TonemapFilter = "zscale=t=linear:npl=100,format=gbrpf32le,zscale=p=bt709,tonemap=tonemap=hable:desat=0,zscale=t=bt709:m=bt709:r=tv,format=yuv420p"
+
+// IsHDR reports whether the first video stream uses a PQ or HLG transfer.
+func IsHDR(filePath string) bool {
+	for _, s := range streams(filePath, "video") {
+		return s.ColorTransfer == "smpte2084" || s.ColorTransfer == "arib-std-b67"
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package media
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestProfileHDR(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{"not said", `{"videoCodecs": ["hevc"]}`, true},
+		{"displays hdr", `{"videoCodecs": ["hevc"], "hdr": true}`, true},
+		{"no hdr", `{"videoCodecs": ["hevc"], "hdr": false}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p DeviceProfile
+			if err := json.Unmarshal([]byte(tt.body), &p); err != nil {
+				t.Fatal(err)
+			}
+			p.Normalize()
+			if got := p.DisplaysHDR(); got != tt.want {
+				t.Errorf("DisplaysHDR() = %v, want %v", got, tt.want)
+			}
+			if got := p.CanCopyVideo("hevc", true); got != tt.want {
+				t.Errorf("CanCopyVideo(hevc, hdr) = %v, want %v", got, tt.want)
+			}
+			if !p.CanCopyVideo("hevc", false) {
+				t.Error("CanCopyVideo(hevc, sdr) = false, want true")
+			}
+		})
+	}
+	if !DefaultProfile().DisplaysHDR() {
+		t.Error("DefaultProfile().DisplaysHDR() = false, want true")
+	}
+}
@@ -0,0 +1,353 @@
+package media
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+type subtitleJob struct {
+	done chan struct{}
+	err  error
+}
+
+// subtitleCache keeps subtitle tracks converted to WebVTT on disk, keyed by
+// the version of the file they come from and the track, and makes
+// concurrent requests for the same track share one ffmpeg run.
+var subtitleCache = struct {
+	mu  sync.Mutex
+	dir string
+	// charset is what external files that are not UTF-8 are read as, when
+	// set; they are guessed otherwise.
+	charset string
+	running map[string]*subtitleJob
+}{
+	running: make(map[string]*subtitleJob),
+}
+
+// SetSubtitleCacheDir sets where converted subtitles are kept. Without one
+// they are kept in a directory under the system temp directory.
+func SetSubtitleCacheDir(dir string) {
+	subtitleCache.mu.Lock()
+	defer subtitleCache.mu.Unlock()
+	subtitleCache.dir = dir
+}
+
+// SetSubtitleCharset sets the character set external subtitle files that
+// are not UTF-8 are read as, by the name ffmpeg's -sub_charenc takes, such as
+// "CP1251". Empty leaves it to SubtitleCharset to guess.
+func SetSubtitleCharset(charset string) {
+	subtitleCache.mu.Lock()
+	defer subtitleCache.mu.Unlock()
+	subtitleCache.charset = charset
+}
+
+// subtitleCacheVersion is bumped whenever conversions change, so files
+// converted the old way are converted again.
+const subtitleCacheVersion = 2
+
+// subtitleKey names the cached conversion of a track: external files by
+// their own version, embedded tracks by the video's and the stream index.
+func subtitleKey(videoPath string, track SubtitleTrack) (string, error) {
+	source := videoPath
+	if track.Path != "" {
+		source = track.Path
+	}
+	abs, err := filepath.Abs(source)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%d\x00%s\x00%d\x00%d\x00", subtitleCacheVersion, abs, info.Size(), info.ModTime().UnixNano())
+	if track.Path == "" {
+		fmt.Fprintf(hash, "%d\x00", track.Index)
+	} else {
+		// Files read in another character set convert differently.
+		subtitleCache.mu.Lock()
+		fmt.Fprintf(hash, "%s\x00", subtitleCache.charset)
+		subtitleCache.mu.Unlock()
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:32], nil
+}
+
+// CachedSubtitle returns the path of track converted to WebVTT, converting
+// it on first use.
+func CachedSubtitle(videoPath string, track SubtitleTrack) (string, error) {
+	key, err := subtitleKey(videoPath, track)
+	if err != nil {
+		return "", err
+	}
+	return cachedSubtitleFile(key+".vtt", func(partial string) error {
+		if track.Path != "" {
+			return ConvertSRTtoVTT(track.Path, partial, 0)
+		}
+		return ExtractSubtitleToSRT(videoPath, track.Index, partial, 0)
+	})
+}
+
+// CachedASS returns the path of an ASS/SSA track as it is, with all its
+// styling, for players that render it themselves. External UTF-8 files are
+// used in place; others are converted to UTF-8, and embedded tracks
+// extracted, on first use.
+func CachedASS(videoPath string, track SubtitleTrack) (string, error) {
+	if !track.Styled() {
+		return "", fmt.Errorf("subtitle codec %s is not ASS/SSA", track.Codec)
+	}
+	charset := ""
+	if track.Path != "" {
+		if charset = SubtitleCharset(track.Path); charset == "" {
+			return track.Path, nil
+		}
+	}
+	key, err := subtitleKey(videoPath, track)
+	if err != nil {
+		return "", err
+	}
+	return cachedSubtitleFile(key+".ass", func(partial string) error {
+		if track.Path != "" {
+			return ConvertASS(track.Path, charset, partial)
+		}
+		return ExtractASS(videoPath, track.Index, partial)
+	})
+}
+
+// CachedFonts returns the directory holding the fonts attached to a video,
+// which styled subtitles rely on, dumping them on first use. It returns ""
+// for videos without font attachments.
+func CachedFonts(videoPath string) (string, error) {
+	if len(FontAttachments(videoPath)) == 0 {
+		return "", nil
+	}
+	key, err := subtitleKey(videoPath, SubtitleTrack{Index: -1})
+	if err != nil {
+		return "", err
+	}
+	return cachedSubtitleFile("fonts_"+key, func(partial string) error {
+		return DumpAttachments(videoPath, partial)
+	})
+}
+
+// cachedSubtitleFile returns the path of name in the subtitle cache,
+// creating it with create on first use. create writes to a temporary path
+// that only takes the name once complete, so a failed run leaves nothing
+// behind.
+func cachedSubtitleFile(name string, create func(partial string) error) (string, error) {
+	c := &subtitleCache
+	c.mu.Lock()
+	dir := c.dir
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "raikiri-subtitles")
+	}
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); err == nil {
+		c.mu.Unlock()
+		// Use keeps entries from being pruned as unused.
+		now := time.Now()
+		os.Chtimes(path, now, now)
+		return path, nil
+	}
+	job, running := c.running[name]
+	if !running {
+		job = &subtitleJob{done: make(chan struct{})}
+		c.running[name] = job
+		go func() {
+			job.err = createCached(dir, path, create)
+			c.mu.Lock()
+			delete(c.running, name)
+			c.mu.Unlock()
+			close(job.done)
+		}()
+	}
+	c.mu.Unlock()
+	<-job.done
+	if job.err != nil {
+		return "", job.err
+	}
+	return path, nil
+}
+
+func createCached(dir, path string, create func(partial string) error) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	partial := path + ".part"
+	os.RemoveAll(partial)
+	if err := create(partial); err != nil {
+		os.RemoveAll(partial)
+		return err
+	}
+	return os.Rename(partial, path)
+}
+
+// PruneSubtitleCache removes converted subtitles and fonts unused for longer
+// than maxAge and returns how many it removed.
+func PruneSubtitleCache(maxAge time.Duration) int {
+	subtitleCache.mu.Lock()
+	dir := subtitleCache.dir
+	subtitleCache.mu.Unlock()
+	entries, err := os.ReadDir(dir)
+	if dir == "" || err != nil {
+		return 0
+	}
+	removed := 0
+	for _, e := range entries {
+		name := e.Name()
+		known := strings.HasSuffix(name, ".vtt") || strings.HasSuffix(name, ".ass") || strings.HasPrefix(name, "fonts_")
+		info, err := e.Info()
+		if err != nil || !known || strings.HasSuffix(name, ".part") || time.Since(info.ModTime()) <= maxAge {
+			continue
+		}
+		if os.RemoveAll(filepath.Join(dir, name)) == nil {
+			removed++
+		}
+	}
+	return removed
+}
+
+// ShiftVTT writes the WebVTT file src to dst with every cue moved offset
+// seconds earlier (later when negative), dropping the cues that end before
+// zero, for streams that start offset seconds into the video and for
+// tracks out of sync.
+func ShiftVTT(src, dst string, offset float64) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	var b strings.Builder
+	sc := bufio.NewScanner(in)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	// Cues are kept or dropped whole, up to the blank line ending them.
+	skip := false
+	var pending []string
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.TrimSpace(line) == "" {
+			if !skip {
+				for _, l := range pending {
+					b.WriteString(l + "\n")
+				}
+				b.WriteString("\n")
+			}
+			skip, pending = false, nil
+			continue
+		}
+		if from, to, settings, ok := parseCueTiming(line); ok {
+			from, to = from-offset, to-offset
+			if to <= 0 {
+				skip = true
+			}
+			line = formatVTTTime(max(from, 0)) + " --> " + formatVTTTime(max(to, 0)) + settings
+		}
+		pending = append(pending, line)
+	}
+	if !skip {
+		for _, l := range pending {
+			b.WriteString(l + "\n")
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return os.WriteFile(dst, []byte(b.String()), 0644)
+}
+
+// Cue is one timed line of a subtitle track, in seconds.
+type Cue struct {
+	Start float64
+	End   float64
+	Text  string
+}
+
+// markup matches WebVTT tags and ASS override blocks left in cue text.
+var markup = regexp.MustCompile(`<[^>]*>|\{[^}]*\}`)
+
+// ReadVTTCues reads the cues of a WebVTT file as plain text, their lines
+// joined by spaces.
+func ReadVTTCues(path string) ([]Cue, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	var cues []Cue
+	var cue *Cue
+	var lines []string
+	flush := func() {
+		if cue != nil {
+			cue.Text = strings.Join(strings.Fields(html.UnescapeString(markup.ReplaceAllString(strings.Join(lines, " "), ""))), " ")
+			if cue.Text != "" {
+				cues = append(cues, *cue)
+			}
+		}
+		cue, lines = nil, nil
+	}
+	sc := bufio.NewScanner(in)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "":
+			flush()
+		case cue != nil:
+			lines = append(lines, line)
+		default:
+			if from, to, _, ok := parseCueTiming(line); ok {
+				cue = &Cue{Start: from, End: to}
+			}
+		}
+	}
+	flush()
+	return cues, sc.Err()
+}
+
+// parseCueTiming reads a cue timing line such as
+// "00:01:02.500 --> 00:01:04.000 line:90%", returning the times in seconds
+// and the cue settings after them.
+func parseCueTiming(line string) (from, to float64, settings string, ok bool) {
+	start, rest, found := strings.Cut(line, " --> ")
+	if !found {
+		return 0, 0, "", false
+	}
+	end, settings, _ := strings.Cut(rest, " ")
+	if settings != "" {
+		settings = " " + settings
+	}
+	from, ok1 := parseVTTTime(strings.TrimSpace(start))
+	to, ok2 := parseVTTTime(strings.TrimSpace(end))
+	return from, to, settings, ok1 && ok2
+}
+
+// parseVTTTime reads a timestamp in the form [hh:]mm:ss.ttt.
+func parseVTTTime(s string) (float64, bool) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, false
+	}
+	var t float64
+	for _, p := range parts {
+		var v float64
+		if _, err := fmt.Sscanf(p, "%g", &v); err != nil {
+			return 0, false
+		}
+		t = t*60 + v
+	}
+	return t, true
+}
+
+func formatVTTTime(t float64) string {
+	ms := int64(t*1000 + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
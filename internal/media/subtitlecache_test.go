@@ -0,0 +1,53 @@
+package media
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestShiftVTT(t *testing.T) {
+	const src = "WEBVTT\n\n" +
+		"00:00:01.000 --> 00:00:02.000\nfirst\n\n" +
+		"00:00:05.500 --> 00:00:07.000 line:90%\nsecond\nline two\n\n" +
+		"01:00:00.000 --> 01:00:01.250\nthird\n"
+	tests := []struct {
+		name   string
+		offset float64
+		want   string
+	}{
+		{"none", 0, src},
+		{"earlier", 3, "WEBVTT\n\n" +
+			"00:00:02.500 --> 00:00:04.000 line:90%\nsecond\nline two\n\n" +
+			"00:59:57.000 --> 00:59:58.250\nthird\n"},
+		{"clipped at zero", 1.5, "WEBVTT\n\n" +
+			"00:00:00.000 --> 00:00:00.500\nfirst\n\n" +
+			"00:00:04.000 --> 00:00:05.500 line:90%\nsecond\nline two\n\n" +
+			"00:59:58.500 --> 00:59:59.750\nthird\n"},
+		{"later", -2.25, "WEBVTT\n\n" +
+			"00:00:03.250 --> 00:00:04.250\nfirst\n\n" +
+			"00:00:07.750 --> 00:00:09.250 line:90%\nsecond\nline two\n\n" +
+			"01:00:02.250 --> 01:00:03.500\nthird\n"},
+		{"past the end", 7200, "WEBVTT\n\n"},
+	}
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.vtt")
+	if err := os.WriteFile(in, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(dir, tt.name+".vtt")
+			if err := ShiftVTT(in, out, tt.offset); err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("ShiftVTT(%v) =\n%s\nwant\n%s", tt.offset, got, tt.want)
+			}
+		})
+	}
+}
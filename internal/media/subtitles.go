@@ -1,14 +1,15 @@
 package media
 
 import (
+	"bytes"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"slices"
-	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 // isSubtitleFile reports whether name has a text-based subtitle extension that
@@ -46,57 +47,350 @@ func FindExternalSubtitles(videoPath string) []string {
 	return subtitles
 }
 
-func GetEmbeddedSubtitleTracks(filePath string) []SubtitleTrack {
-	cmd := exec.Command("ffprobe",
-		"-v", "error",
-		"-select_streams", "s",
-		"-show_entries", "stream=index,codec_name",
-		"-of", "csv=p=0",
-		filePath)
-
-	output, err := cmd.Output()
-	if err != nil {
-		return nil
+// SidecarSubtitles lists the external subtitles named after a video, as in
+// "Movie.en.srt" for "Movie.mkv". Unlike FindExternalSubtitles it leaves out
+// those of other videos in the same folder, such as the other episodes of a
+// season.
+func SidecarSubtitles(videoPath string) []string {
+	stem := strings.TrimSuffix(filepath.Base(videoPath), filepath.Ext(videoPath))
+	var sidecars []string
+	for _, subPath := range FindExternalSubtitles(videoPath) {
+		name := filepath.Base(subPath)
+		if len(name) <= len(stem) || !strings.EqualFold(name[:len(stem)], stem) {
+			continue
+		}
+		// "Show.S01E01.en.srt" is not a sidecar of "Show.S01E0.mkv".
+		if strings.ContainsRune("._- ", rune(name[len(stem)])) {
+			sidecars = append(sidecars, subPath)
+		}
 	}
+	return sidecars
+}
 
-	var tracks []SubtitleTrack
-	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	for _, line := range lines {
-		if line == "" {
-			continue
+// ExternalSubtitleTrack describes a subtitle file from the tags in its
+// name after the video's own, as in "Movie.en.forced.srt" or
+// "Movie.English.SDH.srt". Tags that are neither a language nor a flag make
+// up the title.
+func ExternalSubtitleTrack(videoPath, subPath string) SubtitleTrack {
+	track := SubtitleTrack{Path: subPath, Codec: strings.TrimPrefix(strings.ToLower(filepath.Ext(subPath)), ".")}
+	name := strings.TrimSuffix(filepath.Base(subPath), filepath.Ext(subPath))
+	videoName := strings.TrimSuffix(filepath.Base(videoPath), filepath.Ext(videoPath))
+	if len(name) >= len(videoName) && strings.EqualFold(name[:len(videoName)], videoName) {
+		name = name[len(videoName):]
+	} else {
+		// Files in subs/ are often named after the language alone.
+		name = "." + name
+	}
+	var title []string
+	for _, tag := range strings.FieldsFunc(name, func(r rune) bool { return r == '.' || r == '_' || r == ' ' || r == '-' }) {
+		switch lower := strings.ToLower(tag); {
+		case lower == "forced" || lower == "foreign":
+			track.Forced = true
+		case lower == "default":
+			track.Default = true
+		case lower == "sdh" || lower == "cc" || lower == "hi":
+			track.SDH = true
+		case track.Language == "" && LanguageName(lower) != "":
+			track.Language = lower
+		case track.Language == "" && languageCode(lower) != "":
+			track.Language = languageCode(lower)
+		case strings.Trim(lower, "0123456789") == "":
+			// Track numbers, as in "2_English.srt".
+		default:
+			title = append(title, tag)
+		}
+	}
+	track.Title = strings.Join(title, " ")
+	return track
+}
+
+// SubtitleLabel names a subtitle track for track menus: its title or
+// language with its flags, or fallback when it has neither.
+func SubtitleLabel(t SubtitleTrack, fallback string) string {
+	name := t.Title
+	if lang := LanguageName(t.Language); lang != "" && (name == "" || !strings.Contains(strings.ToLower(name), strings.ToLower(lang))) {
+		if name == "" {
+			name = lang
+		} else {
+			name = lang + " - " + name
 		}
-		parts := strings.Split(line, ",")
-		if len(parts) >= 2 {
-			index, err := strconv.Atoi(parts[0])
-			if err != nil {
-				continue
+	}
+	if name == "" {
+		name = fallback
+	}
+	if t.Forced {
+		name += " (Forced)"
+	}
+	if t.SDH {
+		name += " (SDH)"
+	}
+	return name
+}
+
+// Bitmap subtitles cannot be converted to text; they can only be burned
+// into the video.
+var bitmapSubtitleCodecs = []string{"hdmv_pgs_subtitle", "dvd_subtitle"}
+
+// GetEmbeddedSubtitleTracks lists the text subtitles of a video, then its
+// bitmap ones (PGS and VobSub) flagged for burn-in.
+func GetEmbeddedSubtitleTracks(filePath string) []SubtitleTrack {
+	textBasedCodecs := []string{"subrip", "ass", "ssa", "webvtt", "mov_text", "srt"}
+	var tracks, bitmap []SubtitleTrack
+	for _, s := range streams(filePath, "subtitle") {
+		textBased := slices.Contains(textBasedCodecs, s.CodecName)
+		if textBased || slices.Contains(bitmapSubtitleCodecs, s.CodecName) {
+			track := SubtitleTrack{
+				Index:    s.Index,
+				Codec:    s.CodecName,
+				Language: s.Tags.Language,
+				Title:    s.Tags.Title,
+				Default:  s.Disposition.Default == 1,
+				Forced:   s.Disposition.Forced == 1,
+				SDH:      s.Disposition.HearingImpaired == 1,
+				BurnIn:   !textBased,
 			}
-			codec := parts[1]
-			textBasedCodecs := []string{"subrip", "ass", "ssa", "webvtt", "mov_text", "srt"}
-			if slices.Contains(textBasedCodecs, codec) {
-				tracks = append(tracks, SubtitleTrack{Index: index, Codec: codec})
+			if textBased {
+				tracks = append(tracks, track)
+			} else {
+				bitmap = append(bitmap, track)
 			}
 		}
 	}
+	return append(tracks, bitmap...)
+}
 
-	return tracks
+// seekArgs returns input options that drop cues before offset and shift the
+// rest so they line up with a stream that starts at offset seconds.
+func seekArgs(offset float64) []string {
+	if offset <= 0 {
+		return nil
+	}
+	return []string{"-ss", fmt.Sprintf("%.3f", offset)}
 }
 
-func ExtractSubtitleToSRT(videoPath string, streamIndex int, outputPath string) error {
-	cmd := exec.Command("ffmpeg",
+func ExtractSubtitleToSRT(videoPath string, streamIndex int, outputPath string, offset float64) error {
+	args := append(seekArgs(offset),
 		"-i", videoPath,
 		"-map", fmt.Sprintf("0:%d", streamIndex),
 		"-f", "webvtt",
 		outputPath)
 
-	return cmd.Run()
+	return exec.Command("ffmpeg", args...).Run()
+}
+
+// ConvertASS writes an external ASS/SSA file in another character set to
+// outputPath as UTF-8.
+func ConvertASS(subPath, charset, outputPath string) error {
+	return exec.Command("ffmpeg",
+		"-y",
+		"-sub_charenc", charset,
+		"-i", subPath,
+		"-f", "ass",
+		outputPath).Run()
+}
+
+// SubtitleCharset returns the character set of a subtitle file that is not
+// UTF-8, by the name ffmpeg's -sub_charenc takes: the one set with
+// SetSubtitleCharset, or else a guess of GBK when its non-ASCII bytes pair
+// up as GB2312 characters and Windows-1252 otherwise. Guesses the bytes do
+// not bear out clearly are logged. It returns "" for UTF-8 and for UTF-16
+// with a byte order mark, which ffmpeg reads as they are.
+func SubtitleCharset(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	for _, bom := range [][]byte{{0xEF, 0xBB, 0xBF}, {0xFF, 0xFE}, {0xFE, 0xFF}} {
+		if bytes.HasPrefix(data, bom) {
+			return ""
+		}
+	}
+	if utf8.Valid(data) {
+		return ""
+	}
+	subtitleCache.mu.Lock()
+	charset := subtitleCache.charset
+	subtitleCache.mu.Unlock()
+	if charset != "" {
+		return charset
+	}
+	charset, confident := guessCharset(data)
+	if !confident {
+		log.Printf("WARN [media] unsure of subtitle charset, guessed charset=%s file=%s (set --subtitle-charset if it reads wrong)", charset, filepath.Base(path))
+	}
+	return charset
 }
 
-func ConvertSRTtoVTT(srtPath string, vttPath string) error {
-	cmd := exec.Command("ffmpeg",
+// guessCharset tells GBK from Windows-1252 text and reports whether the
+// guess is clear-cut. Western text has accented letters between ASCII ones,
+// which seldom form GB2312 pairs; Chinese text is almost nothing but. Text
+// in other scripts, such as Cyrillic, runs its non-ASCII bytes together
+// without being Chinese, so a Western guess on such text is unsure.
+func guessCharset(data []byte) (string, bool) {
+	pairs, others, runs := 0, 0, 0
+	for i := 0; i < len(data); i++ {
+		b := data[i]
+		if b < 0x80 {
+			continue
+		}
+		if i+1 < len(data) && data[i+1] >= 0x80 {
+			runs++
+		}
+		if i+1 < len(data) && b >= 0xA1 && b <= 0xF7 && data[i+1] >= 0xA1 && data[i+1] <= 0xFE {
+			pairs++
+			i++
+			continue
+		}
+		others++
+	}
+	if pairs > 0 && pairs >= 4*others {
+		return "GBK", others == 0 || pairs >= 20*others
+	}
+	return "CP1252", runs*4 <= pairs+others
+}
+
+// DialogueTracks picks the text subtitles of a video that carry its
+// dialogue: one per language, sidecar files named after the video first,
+// preferring full tracks to SDH ones and both to forced ones.
+func DialogueTracks(videoPath string) []SubtitleTrack {
+	var tracks []SubtitleTrack
+	for _, subPath := range SidecarSubtitles(videoPath) {
+		tracks = append(tracks, ExternalSubtitleTrack(videoPath, subPath))
+	}
+	tracks = append(tracks, GetEmbeddedSubtitleTracks(videoPath)...)
+
+	rank := func(t SubtitleTrack) int {
+		switch {
+		case t.Forced:
+			return 2
+		case t.SDH:
+			return 1
+		}
+		return 0
+	}
+	var picked []SubtitleTrack
+	byLanguage := make(map[string]int)
+	for _, t := range tracks {
+		if t.BurnIn {
+			continue
+		}
+		// "en" and "eng" are the same language.
+		lang := t.Language
+		if name := LanguageName(lang); name != "" {
+			lang = name
+		}
+		i, seen := byLanguage[lang]
+		switch {
+		case !seen:
+			byLanguage[lang] = len(picked)
+			picked = append(picked, t)
+		case rank(t) < rank(picked[i]):
+			picked[i] = t
+		}
+	}
+	return picked
+}
+
+// ExtractASS copies an embedded ASS/SSA track out of a video unchanged.
+func ExtractASS(videoPath string, streamIndex int, outputPath string) error {
+	return exec.Command("ffmpeg",
+		"-y",
+		"-i", videoPath,
+		"-map", fmt.Sprintf("0:%d", streamIndex),
+		"-c:s", "copy",
+		"-f", "ass",
+		outputPath).Run()
+}
+
+// fontMimeTypes are the MIME types Matroska muxers give font attachments.
+var fontMimeTypes = []string{
+	"application/x-truetype-font", "application/x-font-ttf", "application/x-font-otf",
+	"application/vnd.ms-opentype", "application/font-sfnt", "font/ttf", "font/otf", "font/sfnt",
+	"font/collection", "font/woff", "font/woff2",
+}
+
+// fontExtensions are the file extensions fonts are written out with.
+var fontExtensions = []string{".ttf", ".otf", ".ttc", ".woff", ".woff2"}
+
+// fontAttachment is a font attached to a video: its stream index and the
+// name it is written out as.
+type fontAttachment struct {
+	Index int
+	Name  string
+}
+
+// fontAttachments lists the fonts attached to a video. Only attachments
+// with a font MIME type count, and names come from the file itself, so they
+// are reduced to a plain base name with a font extension, falling back to
+// one made from the stream index.
+func fontAttachments(videoPath string) []fontAttachment {
+	var fonts []fontAttachment
+	seen := make(map[string]bool)
+	for _, s := range streams(videoPath, "attachment") {
+		if !slices.Contains(fontMimeTypes, strings.ToLower(s.Tags.Mimetype)) {
+			continue
+		}
+		name := filepath.Base(filepath.FromSlash(strings.ReplaceAll(s.Tags.Filename, `\`, "/")))
+		if !slices.Contains(fontExtensions, strings.ToLower(filepath.Ext(name))) || strings.HasPrefix(name, ".") {
+			name = fmt.Sprintf("font_%d.ttf", s.Index)
+		}
+		if seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+		fonts = append(fonts, fontAttachment{Index: s.Index, Name: name})
+	}
+	return fonts
+}
+
+// FontAttachments lists the file names of the fonts attached to a video.
+func FontAttachments(videoPath string) []string {
+	var names []string
+	for _, f := range fontAttachments(videoPath) {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+// DumpAttachments writes the fonts attached to a video into dir, under the
+// names FontAttachments lists.
+func DumpAttachments(videoPath, dir string) error {
+	abs, err := filepath.Abs(videoPath)
+	if err != nil {
+		return err
+	}
+	fonts := fontAttachments(videoPath)
+	if len(fonts) == 0 {
+		return fmt.Errorf("no fonts attached to %s", filepath.Base(videoPath))
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	args := []string{"-y"}
+	for _, f := range fonts {
+		args = append(args, fmt.Sprintf("-dump_attachment:%d", f.Index), filepath.Join(dir, f.Name))
+	}
+	args = append(args, "-i", abs)
+	// ffmpeg dumps attachments while opening the input and then fails for
+	// want of an output, so success is judged by what it wrote.
+	runErr := exec.Command("ffmpeg", args...).Run()
+	for _, f := range fonts {
+		if _, err := os.Stat(filepath.Join(dir, f.Name)); err != nil {
+			return fmt.Errorf("font %s not dumped from %s: %v", f.Name, filepath.Base(videoPath), runErr)
+		}
+	}
+	return nil
+}
+
+func ConvertSRTtoVTT(srtPath string, vttPath string, offset float64) error {
+	args := seekArgs(offset)
+	if charset := SubtitleCharset(srtPath); charset != "" {
+		args = append(args, "-sub_charenc", charset)
+	}
+	args = append(args,
 		"-i", srtPath,
 		"-f", "webvtt",
 		vttPath)
 
-	return cmd.Run()
+	return exec.Command("ffmpeg", args...).Run()
 }
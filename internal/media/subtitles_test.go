@@ -0,0 +1,90 @@
+package media
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDialogueTracksSeasonFolder(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"Show.S01E01.mkv",
+		"Show.S01E01.en.srt",
+		"Show.S01E01.es.forced.srt",
+		"Show.S01E02.mkv",
+		"Show.S01E02.en.srt",
+		"Show.S01E02.fr.srt",
+		"Show.S01E0.mkv",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		video string
+		want  []string
+	}{
+		{"Show.S01E01.mkv", []string{"Show.S01E01.en.srt", "Show.S01E01.es.forced.srt"}},
+		{"Show.S01E02.mkv", []string{"Show.S01E02.en.srt", "Show.S01E02.fr.srt"}},
+		{"Show.S01E0.mkv", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.video, func(t *testing.T) {
+			var got []string
+			for _, track := range DialogueTracks(filepath.Join(dir, tt.video)) {
+				got = append(got, filepath.Base(track.Path))
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("DialogueTracks() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("DialogueTracks() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSubtitleCharset(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		want      string
+		confident bool
+	}{
+		{"ascii", "1\n00:00:01,000 --> 00:00:02,000\nHello\n", "", true},
+		{"utf-8", "Café, déjà vu", "", true},
+		{"utf-8 bom", "\xef\xbb\xbfHello", "", true},
+		{"utf-16 bom", "\xff\xfeH\x00i\x00", "", true},
+		{"windows-1252", "Caf\xe9, d\xe9j\xe0 vu \xe0 No\xebl", "CP1252", true},
+		{"gbk", "\xc4\xe3\xba\xc3\xa3\xac\xca\xc0\xbd\xe7\xa1\xa3\xce\xd2\xc3\xc7\xd7\xdf\xb0\xc9", "GBK", true},
+		// Cyrillic in Windows-1251 is neither; it needs the charset set.
+		{"windows-1251", "\xcf\xf0\xe8\xe2\xe5\xf2, \xea\xe0\xea \xe4\xe5\xeb\xe0? \xc2\xf1\xb8 \xf5\xee\xf0\xee\xf8\xee", "CP1252", false},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".srt")
+			if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if got := SubtitleCharset(path); got != tt.want {
+				t.Errorf("SubtitleCharset() = %q, want %q", got, tt.want)
+			}
+			if tt.want == "" {
+				return
+			}
+			if _, confident := guessCharset([]byte(tt.data)); confident != tt.confident {
+				t.Errorf("guessCharset() confident = %v, want %v", confident, tt.confident)
+			}
+
+			SetSubtitleCharset("CP1251")
+			defer SetSubtitleCharset("")
+			if got := SubtitleCharset(path); got != "CP1251" {
+				t.Errorf("SubtitleCharset() = %q with CP1251 set, want CP1251", got)
+			}
+		})
+	}
+}
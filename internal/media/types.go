@@ -1,12 +1,18 @@
 package media
 
+import (
+	"fmt"
+	"path/filepath"
+)
+
 type FileEntry struct {
-	Name     string `json:"name"`
-	Path     string `json:"path"`
-	Type     string `json:"type"`
-	Size     string `json:"size"`
-	Thumb    string `json:"thumb,omitempty"`
-	Modified string `json:"modified,omitempty"`
+	Name     string  `json:"name"`
+	Path     string  `json:"path"`
+	Type     string  `json:"type"`
+	Size     string  `json:"size"`
+	Thumb    string  `json:"thumb,omitempty"`
+	Modified string  `json:"modified,omitempty"`
+	Duration float64 `json:"duration,omitempty"`
 }
 
 type AudioTrack struct {
@@ -14,10 +20,39 @@ type AudioTrack struct {
 	Codec    string `json:"codec"`
 	Profile  string `json:"profile"`
 	Language string `json:"language"`
+	Title    string `json:"title,omitempty"`
 	Channels int    `json:"channels"`
+	Label    string `json:"label"`
 }
 
+// SubtitleTrack is an embedded subtitle stream, or an external subtitle
+// file (Path set, Index unused).
 type SubtitleTrack struct {
-	Index int    `json:"index"`
-	Codec string `json:"codec"`
+	Index    int    `json:"index"`
+	Path     string `json:"-"`
+	Codec    string `json:"codec"`
+	Language string `json:"language,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Default  bool   `json:"default,omitempty"`
+	Forced   bool   `json:"forced,omitempty"`
+	// SDH marks subtitles for the deaf and hard of hearing.
+	SDH bool `json:"sdh,omitempty"`
+	// BurnIn marks bitmap subtitles, which can only be burned into the
+	// video.
+	BurnIn bool `json:"burnIn,omitempty"`
+}
+
+// Styled reports whether the track is ASS/SSA, which players with a libass
+// renderer can show with its original styling.
+func (t SubtitleTrack) Styled() bool {
+	return !t.BurnIn && (t.Codec == "ass" || t.Codec == "ssa")
+}
+
+// ID names the track independently of the order tracks are listed in:
+// external files by their name, embedded tracks by their stream index.
+func (t SubtitleTrack) ID() string {
+	if t.Path != "" {
+		return "file:" + filepath.Base(t.Path)
+	}
+	return fmt.Sprintf("stream:%d", t.Index)
 }
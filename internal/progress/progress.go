@@ -0,0 +1,161 @@
+package progress
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tanq16/raikiri/internal/store"
+)
+
+const progressFile = "progress.json"
+
+// Playback past this fraction of the duration counts as watched.
+const completedRatio = 0.95
+
+// Players report their position every few seconds, so updates are written
+// out at most this often rather than one by one.
+const writeDelay = 10 * time.Second
+
+type Entry struct {
+	Library   string    `json:"library"`
+	Path      string    `json:"path"`
+	Position  float64   `json:"position"`
+	Duration  float64   `json:"duration"`
+	Completed bool      `json:"completed"`
+	Updated   time.Time `json:"updated"`
+}
+
+// Resume returns the position playback should start from, which is zero for
+// finished items.
+func (e Entry) Resume() float64 {
+	if e.Completed {
+		return 0
+	}
+	return e.Position
+}
+
+// Store keeps watch progress per user and library path in progress.json.
+// Close writes out updates still pending.
+type Store struct {
+	file  store.JSONFile
+	mu    sync.RWMutex
+	users map[string]map[string]*Entry // user -> library/path -> entry
+	// pending is set while updates wait to be written.
+	pending *time.Timer
+}
+
+func Open(dataDir string) (*Store, error) {
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	s := &Store{
+		file:  store.JSONFile{Path: filepath.Join(dataDir, progressFile)},
+		users: make(map[string]map[string]*Entry),
+	}
+	if err := s.file.Read(&s.users); err != nil {
+		return nil, err
+	}
+	// A file holding null decodes to a nil map.
+	if s.users == nil {
+		s.users = make(map[string]map[string]*Entry)
+	}
+	return s, nil
+}
+
+// Close writes out pending updates.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending == nil {
+		return nil
+	}
+	s.pending.Stop()
+	s.pending = nil
+	return s.file.Write(s.users)
+}
+
+// flush writes out the updates made since the last write.
+func (s *Store) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending == nil {
+		return
+	}
+	s.pending = nil
+	if err := s.file.Write(s.users); err != nil {
+		log.Printf("ERROR [progress] failed to save watch progress: %v", err)
+	}
+}
+
+func key(library, path string) string {
+	return library + "/" + path
+}
+
+func (s *Store) Get(user, library, path string) (Entry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, ok := s.users[user][key(library, path)]
+	if !ok {
+		return Entry{Library: library, Path: path}, false
+	}
+	return *e, true
+}
+
+// List returns a user's entries for library (all libraries when empty),
+// most recently updated first.
+func (s *Store) List(user, library string) []Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var list []Entry
+	for _, e := range s.users[user] {
+		if library == "" || e.Library == library {
+			list = append(list, *e)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Updated.After(list[j].Updated) })
+	return list
+}
+
+// Update records a playback position. Entries are marked completed when the
+// caller says so or the position is close enough to the end. The file is
+// written within writeDelay.
+func (s *Store) Update(user string, e Entry) (Entry, error) {
+	if e.Position < 0 {
+		e.Position = 0
+	}
+	if e.Duration > 0 && e.Position/e.Duration >= completedRatio {
+		e.Completed = true
+	}
+	e.Updated = time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.users[user] == nil {
+		s.users[user] = make(map[string]*Entry)
+	}
+	s.users[user][key(e.Library, e.Path)] = &e
+	if s.pending == nil {
+		s.pending = time.AfterFunc(writeDelay, s.flush)
+	}
+	return e, nil
+}
+
+func (s *Store) Delete(user, library, path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[user][key(library, path)]; !ok {
+		return nil
+	}
+	delete(s.users[user], key(library, path))
+	// Pending updates go out with the deletion.
+	if s.pending != nil {
+		s.pending.Stop()
+		s.pending = nil
+	}
+	return s.file.Write(s.users)
+}
@@ -0,0 +1,39 @@
+package progress
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUpdateWrittenOnClose(t *testing.T) {
+	dir := t.TempDir()
+	// A file holding null must not leave the store unusable.
+	if err := os.WriteFile(filepath.Join(dir, progressFile), []byte("null"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pos := range []float64{10, 20, 30} {
+		if _, err := s.Update("alice", Entry{Library: "videos", Path: "a.mkv", Position: pos, Duration: 100}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, progressFile)); string(data) != "null" {
+		t.Fatalf("progress written before the write delay: %s", data)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, ok := s.Get("alice", "videos", "a.mkv")
+	if !ok || e.Position != 30 {
+		t.Fatalf("Get() = %+v, %v after reopening, want position 30", e, ok)
+	}
+}
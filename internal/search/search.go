@@ -0,0 +1,205 @@
+package search
+
+import (
+	"path"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Weights for where a query term matched. Names count more than the folders
+// a file sits in, so "matrix" ranks The Matrix.mkv above a file inside a
+// folder called Matrix.
+const (
+	nameWeight = 1.0
+	pathWeight = 0.5
+)
+
+// Scores for how a term matched a token.
+const (
+	exactScore  = 1.0
+	prefixScore = 0.8
+	substrScore = 0.6
+	fuzzyScore  = 0.5
+	typoPenalty = 0.15
+)
+
+// Tokenize lowercases s and splits it into letter and digit runs, so
+// "Some.Show.S01E02" becomes [some show s01e02].
+func Tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// Query is a parsed search string.
+type Query struct {
+	Raw   string
+	Terms []string
+}
+
+func Parse(q string) Query {
+	return Query{Raw: strings.ToLower(strings.TrimSpace(q)), Terms: Tokenize(q)}
+}
+
+func (q Query) Empty() bool {
+	return len(q.Terms) == 0
+}
+
+// maxTypos is how many edits a term of the given length may be off by.
+func maxTypos(n int) int {
+	switch {
+	case n <= 3:
+		return 0
+	case n <= 7:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// matchTerm scores a single query term against one token, or returns zero.
+func matchTerm(term, token string) float64 {
+	switch {
+	case term == token:
+		return exactScore
+	case strings.HasPrefix(token, term):
+		return prefixScore
+	case len(term) >= 3 && strings.Contains(token, term):
+		return substrScore
+	}
+	// Numbers are identifiers (years, episodes), so they never match loosely.
+	limit := maxTypos(len([]rune(term)))
+	if limit == 0 || strings.ContainsFunc(term, unicode.IsNumber) {
+		return 0
+	}
+	// Compare against the whole token and against a prefix of the same
+	// length, so a typo in a partially typed word still matches.
+	d := distance(term, token)
+	if tr := []rune(token); len(tr) > len([]rune(term)) {
+		d = min(d, distance(term, string(tr[:len([]rune(term))])))
+	}
+	if d > limit {
+		return 0
+	}
+	return fuzzyScore - typoPenalty*float64(d-1)
+}
+
+func bestMatch(term string, tokens []string) float64 {
+	best := 0.0
+	for _, tok := range tokens {
+		if s := matchTerm(term, tok); s > best {
+			best = s
+			if best == exactScore {
+				break
+			}
+		}
+	}
+	return best
+}
+
+// Score ranks the library entry at rel (a slash-separated path) against q.
+// Every term has to match the name or one of the parent folders; the result
+// is zero when one does not. File extensions are ignored, folder names are
+// matched whole.
+func Score(q Query, rel string, isDir bool) float64 {
+	name := path.Base(rel)
+	stem := name
+	if !isDir {
+		stem = strings.TrimSuffix(name, path.Ext(name))
+	}
+	nameTokens := Tokenize(stem)
+	var dirTokens []string
+	if dir := path.Dir(rel); dir != "." {
+		dirTokens = Tokenize(dir)
+	}
+
+	total := 0.0
+	for _, term := range q.Terms {
+		s := bestMatch(term, nameTokens) * nameWeight
+		if s < exactScore*nameWeight {
+			s = max(s, bestMatch(term, dirTokens)*pathWeight)
+		}
+		if s == 0 {
+			return 0
+		}
+		total += s
+	}
+	score := total / float64(len(q.Terms))
+
+	// Phrase bonuses reward names containing the query as typed.
+	lowerStem := strings.ToLower(stem)
+	switch {
+	case lowerStem == q.Raw:
+		score += 1
+	case strings.HasPrefix(lowerStem, q.Raw):
+		score += 0.5
+	case strings.Contains(lowerStem, q.Raw):
+		score += 0.3
+	}
+	// Among equal matches prefer shorter, more specific names.
+	score -= 0.002 * float64(min(len(nameTokens), 50))
+	return score
+}
+
+// MatchText scores a line of text, such as subtitle dialogue, against q.
+// Every term has to be a word of the line, the last one only its start as
+// it may still be being typed; lines with the terms in the query's order
+// rank above the rest. The result is zero when a term is missing.
+func MatchText(q Query, text string) float64 {
+	tokens := Tokenize(text)
+	matches := func(i int, token string) bool {
+		if i == len(q.Terms)-1 {
+			return strings.HasPrefix(token, q.Terms[i])
+		}
+		return token == q.Terms[i]
+	}
+	for i := range q.Terms {
+		if !slices.ContainsFunc(tokens, func(t string) bool { return matches(i, t) }) {
+			return 0
+		}
+	}
+	score := 1.0
+	for start := 0; start+len(q.Terms) <= len(tokens); start++ {
+		phrase := true
+		for i := range q.Terms {
+			if !matches(i, tokens[start+i]) {
+				phrase = false
+				break
+			}
+		}
+		if phrase {
+			score += 1
+			break
+		}
+	}
+	// Among equal matches prefer shorter lines.
+	return score - 0.002*float64(min(len(tokens), 50))
+}
+
+// distance is the optimal string alignment distance between a and b:
+// insertions, deletions, substitutions and adjacent transpositions.
+func distance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,158 @@
+package search
+
+import "testing"
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"", "abc", 3},
+		{"matrix", "matrix", 0},
+		{"matrix", "matrx", 1},
+		{"matrix", "matirx", 1},
+		{"kitten", "sitting", 3},
+		{"café", "cafe", 1},
+	}
+	for _, tt := range tests {
+		if got := distance(tt.a, tt.b); got != tt.want {
+			t.Errorf("distance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestMatchTerm(t *testing.T) {
+	tests := []struct {
+		name        string
+		term, token string
+		want        float64
+	}{
+		{"exact", "matrix", "matrix", exactScore},
+		{"prefix", "mat", "matrix", prefixScore},
+		{"substring", "trix", "matrix", substrScore},
+		{"short substring", "at", "matrix", 0},
+		{"short term has no typo budget", "mta", "mat", 0},
+		{"one typo at four letters", "hose", "house", fuzzyScore},
+		{"two typos at four letters", "hsoe", "house", 0},
+		{"one typo at seven letters", "fnatasy", "fantasy", fuzzyScore},
+		{"two typos at seven letters", "fnatsay", "fantasy", 0},
+		{"two typos at eight letters", "intrestelar", "interstellar", fuzzyScore - typoPenalty},
+		{"three typos at eight letters", "intrstlar", "interstellar", 0},
+		{"typo in a partial word", "interstal", "interstellar", fuzzyScore},
+		{"episode", "s01e02", "s01e02", exactScore},
+		{"other episode", "s01e02", "s01e03", 0},
+		{"other year", "1999", "1998", 0},
+		{"episode prefix", "s01", "s01e03", prefixScore},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchTerm(tt.term, tt.token); got != tt.want {
+				t.Errorf("matchTerm(%q, %q) = %v, want %v", tt.term, tt.token, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScoreRanking(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		// paths are in the order they must rank.
+		paths []string
+	}{
+		{"exact, prefix, path, fuzzy", "interstellar", []string{
+			"Movies/Interstellar.mkv",
+			"Movies/Interstellarium.mkv",
+			"Movies/Interstellar/Making Of.mkv",
+			"Movies/Intrestelar.mkv",
+		}},
+		{"name above folder", "matrix", []string{
+			"Movies/The Matrix.mkv",
+			"Movies/Matrix/Behind the Scenes.mkv",
+		}},
+		{"shorter names first", "matrix", []string{
+			"Movies/Matrix.mkv",
+			"Movies/Matrix Reloaded.mkv",
+			"Movies/Matrix Reloaded Extended Cut.mkv",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := Parse(tt.query)
+			scores := make([]float64, len(tt.paths))
+			for i, p := range tt.paths {
+				if scores[i] = Score(q, p, false); scores[i] <= 0 {
+					t.Fatalf("Score(%q, %q) = %v, want a match", tt.query, p, scores[i])
+				}
+			}
+			for i := 1; i < len(scores); i++ {
+				if scores[i] >= scores[i-1] {
+					t.Errorf("%q scored %v, not below %q at %v", tt.paths[i], scores[i], tt.paths[i-1], scores[i-1])
+				}
+			}
+		})
+	}
+}
+
+func TestScoreMatches(t *testing.T) {
+	tests := []struct {
+		query string
+		path  string
+		isDir bool
+		match bool
+	}{
+		{"s01e02", "Shows/Some Show/Some.Show.S01E02.mkv", false, true},
+		{"s01e02", "Shows/Some Show/Some.Show.S01E03.mkv", false, false},
+		{"matrix 1999", "Movies/Matrix (1999).mkv", false, true},
+		{"matrix 2000", "Movies/Matrix (1999).mkv", false, false},
+		{"matrix zzz", "Movies/Matrix.mkv", false, false},
+		{"some show", "Shows/Some Show/Some.Show.S01E02.mkv", false, true},
+		// Extensions are ignored on files, folder names are matched whole.
+		{"mkv", "Movies/Matrix.mkv", false, false},
+		{"mkv", "Movies/Matrix.mkv", true, true},
+	}
+	for _, tt := range tests {
+		if got := Score(Parse(tt.query), tt.path, tt.isDir); (got > 0) != tt.match {
+			t.Errorf("Score(%q, %q, %v) = %v, want match %v", tt.query, tt.path, tt.isDir, got, tt.match)
+		}
+	}
+}
+
+func TestMatchText(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		text  string
+		match bool
+	}{
+		{"phrase", "on a break", "We were on a break!", true},
+		{"case and punctuation", "ON A BREAK", "we were... on a break?", true},
+		{"last term typed partly", "on a bre", "We were on a break!", true},
+		{"earlier terms are whole words", "bre on", "We were on a break!", false},
+		{"out of order", "break on", "We were on a break!", true},
+		{"missing term", "on a holiday", "We were on a break!", false},
+		{"no typos", "on a braek", "We were on a break!", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchText(Parse(tt.query), tt.text); (got > 0) != tt.match {
+				t.Errorf("MatchText(%q, %q) = %v, want match %v", tt.query, tt.text, got, tt.match)
+			}
+		})
+	}
+
+	ranked := []string{
+		"On a break.",
+		"We were on a break!",
+		"We were on a break, and you know it, Rachel.",
+		"A break? We were on it.",
+	}
+	q := Parse("on a break")
+	for i := 1; i < len(ranked); i++ {
+		prev, cur := MatchText(q, ranked[i-1]), MatchText(q, ranked[i])
+		if cur >= prev {
+			t.Errorf("MatchText(%q) = %v, not below %q at %v", ranked[i], cur, ranked[i-1], prev)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tanq16/raikiri/internal/media"
+)
+
+// DefaultLadder is the adaptive bitrate ladder used unless the server is
+// configured with another one.
+const DefaultLadder = "1080:8000,720:4000,480:1500"
+
+// Audio bitrate of transcoded HLS audio, in kbit/s.
+const hlsAudioBitrate = 192
+
+// Rendition is one rung of the adaptive bitrate ladder: a transcode scaled
+// down to Height with video capped at Bitrate kbit/s.
+type Rendition struct {
+	Height  int
+	Bitrate int
+}
+
+func (r Rendition) Name() string {
+	return fmt.Sprintf("%dp", r.Height)
+}
+
+// ParseLadder reads a comma-separated list of height:kbps pairs such as
+// "1080:8000,720:4000" (a "p" or "k" suffix is allowed). An empty string
+// yields an empty ladder, which disables adaptive streaming. Rungs are
+// returned from highest to lowest.
+func ParseLadder(s string) ([]Rendition, error) {
+	var ladder []Rendition
+	for part := range strings.SplitSeq(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		h, b, ok := strings.Cut(part, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid ladder rung %q, expected height:kbps", part)
+		}
+		height, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(h), "p"))
+		if err != nil || height < 144 {
+			return nil, fmt.Errorf("invalid height in ladder rung %q", part)
+		}
+		bitrate, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(b), "k"))
+		if err != nil || bitrate < 100 {
+			return nil, fmt.Errorf("invalid bitrate in ladder rung %q", part)
+		}
+		// Encoders need even dimensions.
+		ladder = append(ladder, Rendition{Height: height &^ 1, Bitrate: bitrate})
+	}
+	sort.Slice(ladder, func(i, j int) bool { return ladder[i].Height > ladder[j].Height })
+	return ladder, nil
+}
+
+// StreamRendition is the video a stream actually delivers, reported back
+// to the client when it starts.
+type StreamRendition struct {
+	Width      int    `json:"width,omitempty"`
+	Height     int    `json:"height,omitempty"`
+	Bitrate    int    `json:"bitrate,omitempty"` // kbit/s including audio; an estimate for copies
+	VideoCodec string `json:"videoCodec"`
+	Transcoded bool   `json:"transcoded"`
+}
+
+// capRendition picks the transcode for a client asking for at most
+// maxHeight pixels and maxKbps kbit/s in total (zero meaning no limit). The
+// height is never raised above the source and is kept even; the video
+// bitrate suits the height and leaves room for audio under maxKbps.
+func capRendition(srcHeight, maxHeight, maxKbps int) Rendition {
+	height := srcHeight
+	if maxHeight > 0 && (height <= 0 || height > maxHeight) {
+		height = maxHeight
+	}
+	if height <= 0 {
+		height = 1080
+	}
+	height &^= 1
+	bitrate := bitrateForHeight(height)
+	if maxKbps > 0 {
+		bitrate = max(min(bitrate, maxKbps-hlsAudioBitrate), 100)
+	}
+	return Rendition{Height: height, Bitrate: bitrate}
+}
+
+// bitrateForHeight is a video bitrate in kbit/s that looks good at height,
+// scaled by pixel count from 8 Mbit/s at 1080p.
+func bitrateForHeight(height int) int {
+	return max(8000*height*height/(1080*1080), 300)
+}
+
+// transcodeArgs encodes video to h.264. A non-zero height scales it,
+// keeping the aspect ratio with an even width; a non-zero kbps holds the
+// bitrate there through the VBV buffer so segments stay within what a
+// variant advertises, otherwise quality is constant. tonemap maps HDR to SDR.
+func transcodeArgs(height, kbps int, tonemap bool) []string {
+	var filters []string
+	if height > 0 {
+		filters = append(filters, fmt.Sprintf("scale=-2:%d", height))
+	}
+	if tonemap {
+		filters = append(filters, media.TonemapFilter)
+	}
+	var args []string
+	if len(filters) > 0 {
+		args = append(args, "-vf", strings.Join(filters, ","))
+	}
+	args = append(args, "-c:v", "libx264", "-preset", "fast")
+	if kbps > 0 {
+		return append(args,
+			"-b:v", fmt.Sprintf("%dk", kbps),
+			"-maxrate", fmt.Sprintf("%dk", kbps),
+			"-bufsize", fmt.Sprintf("%dk", kbps*2),
+		)
+	}
+	return append(args, "-crf", "23")
+}
+
+// scaledWidth is the even width ffmpeg's scale=-2:height picks.
+func scaledWidth(srcWidth, srcHeight, height int) int {
+	if srcWidth <= 0 || srcHeight <= 0 {
+		return 0
+	}
+	return (srcWidth*height/srcHeight + 1) &^ 1
+}
+
+// variantInfo describes one entry of a master playlist.
+type variantInfo struct {
+	name      string
+	bandwidth int // bits per second
+	width     int
+	height    int
+}
+
+// audioInfo describes one alternate audio rendition of a master playlist.
+type audioInfo struct {
+	name     string
+	index    int // source stream
+	label    string
+	language string
+	channels int
+}
+
+// masterPlaylist names the master playlist with base name base that
+// starts on audio track index: the default track gets the base name alone.
+func masterPlaylist(base string, audio []audioInfo, index int) string {
+	if len(audio) == 0 || index == audio[0].index {
+		return base + ".m3u8"
+	}
+	return fmt.Sprintf("%s_a%d.m3u8", base, index)
+}
+
+// variantPlaylist is the media playlist of a variant, relative to the
+// master playlist; a single stream lives in the stream directory itself.
+func variantPlaylist(name string) string {
+	if name == "" {
+		return "index.m3u8"
+	}
+	return name + "/index.m3u8"
+}
+
+// writeMasterPlaylists lists the renditions of a stream, each served from
+// its own subdirectory, and its subtitles. Audio renditions, when there are
+// any, come first with the default track, and every other track gets a
+// master playlist of its own that selects it instead, as players start on
+// the DEFAULT one.
+func writeMasterPlaylists(dir, base string, variants []variantInfo, audio []audioInfo, subtitles []subtitleInfo) error {
+	if len(audio) == 0 {
+		return writeMasterPlaylist(filepath.Join(dir, base+".m3u8"), variants, nil, -1, subtitles)
+	}
+	for _, a := range audio {
+		if err := writeMasterPlaylist(filepath.Join(dir, masterPlaylist(base, audio, a.index)), variants, audio, a.index, subtitles); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeMasterPlaylist(path string, variants []variantInfo, audio []audioInfo, defaultAudio int, subtitles []subtitleInfo) error {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-INDEPENDENT-SEGMENTS\n")
+	for _, a := range audio {
+		isDefault := "NO"
+		if a.index == defaultAudio {
+			isDefault = "YES"
+		}
+		fmt.Fprintf(&b, "#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"%s\",", quotable(a.label))
+		if a.language != "" && a.language != "und" {
+			fmt.Fprintf(&b, "LANGUAGE=\"%s\",", quotable(a.language))
+		}
+		fmt.Fprintf(&b, "DEFAULT=%s,AUTOSELECT=YES,CHANNELS=\"%d\",URI=\"%s/index.m3u8\"\n", isDefault, a.channels, a.name)
+	}
+	names := make(map[string]bool)
+	hasSubtitles, hasDefault := false, false
+	for _, sub := range subtitles {
+		if sub.BurnIn {
+			continue
+		}
+		hasSubtitles = true
+		// Names must be unique within the group.
+		name := sub.Label
+		if names[name] {
+			name = fmt.Sprintf("%s [%d]", name, sub.Index)
+		}
+		names[name] = true
+		fmt.Fprintf(&b, "#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"subs\",NAME=\"%s\",", quotable(name))
+		if sub.Language != "" && sub.Language != "und" {
+			fmt.Fprintf(&b, "LANGUAGE=\"%s\",", quotable(sub.Language))
+		}
+		// Only one rendition of a group may be the default.
+		isDefault := sub.Default && !hasDefault
+		hasDefault = hasDefault || isDefault
+		fmt.Fprintf(&b, "DEFAULT=%s,AUTOSELECT=%s,FORCED=%s,URI=\"sub_%d.m3u8\"\n",
+			yesNo(isDefault), yesNo(sub.Default || sub.Forced), yesNo(sub.Forced), sub.Index)
+	}
+	for _, v := range variants {
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d", v.bandwidth)
+		if v.width > 0 && v.height > 0 {
+			fmt.Fprintf(&b, ",RESOLUTION=%dx%d", v.width, v.height)
+		}
+		if len(audio) > 0 {
+			b.WriteString(",AUDIO=\"audio\"")
+		}
+		if hasSubtitles {
+			b.WriteString(",SUBTITLES=\"subs\"")
+		}
+		fmt.Fprintf(&b, "\n%s\n", variantPlaylist(v.name))
+	}
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// writeSubtitlePlaylist writes the playlist of subtitle track index: its
+// whole WebVTT file as a single segment.
+func writeSubtitlePlaylist(dir string, index int, duration float64) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(duration)))
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n")
+	fmt.Fprintf(&b, "#EXTINF:%.6f,\nsub_%d.vtt\n#EXT-X-ENDLIST\n", duration, index)
+	return os.WriteFile(filepath.Join(dir, fmt.Sprintf("sub_%d.m3u8", index)), []byte(b.String()), 0644)
+}
+
+// quotable strips what a quoted playlist attribute cannot contain.
+func quotable(s string) string {
+	return strings.NewReplacer(`"`, "'", "\n", " ", "\r", " ").Replace(s)
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "YES"
+	}
+	return "NO"
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseLadder(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []Rendition
+		wantErr bool
+	}{
+		{in: "", want: nil},
+		{in: " , ", want: nil},
+		{in: DefaultLadder, want: []Rendition{{1080, 8000}, {720, 4000}, {480, 1500}}},
+		{in: "480p:1500k, 1080p:8000k", want: []Rendition{{1080, 8000}, {480, 1500}}},
+		{in: "721:4000", want: []Rendition{{720, 4000}}},
+		{in: "144:100", want: []Rendition{{144, 100}}},
+		{in: "720", wantErr: true},
+		{in: "720:", wantErr: true},
+		{in: "abc:4000", wantErr: true},
+		{in: "100:4000", wantErr: true},
+		{in: "720:99", wantErr: true},
+		{in: "1080:8000,720:fast", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLadder(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseLadder(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("ParseLadder(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCapRendition(t *testing.T) {
+	tests := []struct {
+		name                        string
+		srcHeight, maxHeight, maxKb int
+		want                        Rendition
+	}{
+		{"no limits", 1080, 0, 0, Rendition{1080, 8000}},
+		{"height capped", 2160, 720, 0, Rendition{720, 3555}},
+		{"never raised", 480, 1080, 0, Rendition{480, 1580}},
+		{"odd height", 1080, 721, 0, Rendition{720, 3555}},
+		{"unknown source", 0, 0, 0, Rendition{1080, 8000}},
+		{"unknown source capped", 0, 480, 0, Rendition{480, 1580}},
+		{"room for audio", 1080, 0, 3000, Rendition{1080, 3000 - hlsAudioBitrate}},
+		{"bitrate above need", 480, 0, 20000, Rendition{480, 1580}},
+		{"bitrate floor", 1080, 0, 200, Rendition{1080, 100}},
+		{"tiny height", 1080, 144, 0, Rendition{144, 300}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := capRendition(tt.srcHeight, tt.maxHeight, tt.maxKb); got != tt.want {
+				t.Errorf("capRendition(%d, %d, %d) = %v, want %v", tt.srcHeight, tt.maxHeight, tt.maxKb, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteMasterPlaylist(t *testing.T) {
+	variants := []variantInfo{
+		{name: "1080p", bandwidth: 8192000, width: 1920, height: 1080},
+		{name: "720p", bandwidth: 4192000, width: 1280, height: 720},
+	}
+	audio := []audioInfo{
+		{name: "audio_1", index: 1, label: "English (5.1)", language: "eng", channels: 6},
+		{name: "audio_2", index: 2, label: `Commentary "Director"`, language: "und", channels: 2},
+	}
+	subtitles := []subtitleInfo{
+		{Index: 1, Label: "English", Language: "eng", Default: true},
+		{Index: 2, Label: "English", Language: "eng", Default: true, Forced: true},
+		{Index: 3, Label: "Signs", BurnIn: true},
+		{Index: 4, Label: "French", Language: "fre", Forced: true},
+	}
+	tests := []struct {
+		name         string
+		variants     []variantInfo
+		audio        []audioInfo
+		defaultAudio int
+		subtitles    []subtitleInfo
+		want         string
+	}{
+		{
+			name:         "single stream",
+			variants:     []variantInfo{{bandwidth: 5000000}},
+			defaultAudio: -1,
+			want: "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-INDEPENDENT-SEGMENTS\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=5000000\nindex.m3u8\n",
+		},
+		{
+			name:         "renditions with audio and subtitles",
+			variants:     variants,
+			audio:        audio,
+			defaultAudio: 2,
+			subtitles:    subtitles,
+			want: "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-INDEPENDENT-SEGMENTS\n" +
+				"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"English (5.1)\",LANGUAGE=\"eng\",DEFAULT=NO,AUTOSELECT=YES,CHANNELS=\"6\",URI=\"audio_1/index.m3u8\"\n" +
+				"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"Commentary 'Director'\",DEFAULT=YES,AUTOSELECT=YES,CHANNELS=\"2\",URI=\"audio_2/index.m3u8\"\n" +
+				"#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"subs\",NAME=\"English\",LANGUAGE=\"eng\",DEFAULT=YES,AUTOSELECT=YES,FORCED=NO,URI=\"sub_1.m3u8\"\n" +
+				"#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"subs\",NAME=\"English [2]\",LANGUAGE=\"eng\",DEFAULT=NO,AUTOSELECT=YES,FORCED=YES,URI=\"sub_2.m3u8\"\n" +
+				"#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"subs\",NAME=\"French\",LANGUAGE=\"fre\",DEFAULT=NO,AUTOSELECT=YES,FORCED=YES,URI=\"sub_4.m3u8\"\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=8192000,RESOLUTION=1920x1080,AUDIO=\"audio\",SUBTITLES=\"subs\"\n1080p/index.m3u8\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=4192000,RESOLUTION=1280x720,AUDIO=\"audio\",SUBTITLES=\"subs\"\n720p/index.m3u8\n",
+		},
+		{
+			name:         "only bitmap subtitles",
+			variants:     variants[:1],
+			defaultAudio: -1,
+			subtitles:    subtitles[2:3],
+			want: "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-INDEPENDENT-SEGMENTS\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=8192000,RESOLUTION=1920x1080\n1080p/index.m3u8\n",
+		},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "master.m3u8")
+			if err := writeMasterPlaylist(path, tt.variants, tt.audio, tt.defaultAudio, tt.subtitles); err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("writeMasterPlaylist() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
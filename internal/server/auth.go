@@ -0,0 +1,310 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tanq16/raikiri/internal/auth"
+)
+
+const sessionCookie = "raikiri_session"
+
+type ctxKey int
+
+const userCtxKey ctxKey = iota
+
+// Paths reachable without logging in: the login page itself and the static
+// assets it needs.
+func isPublicPath(path string) bool {
+	return path == "/login" || path == "/api/login" || strings.HasPrefix(path, "/static/")
+}
+
+// mediaPath reports whether path serves media that players and image
+// loaders fetch by URL, which cannot carry a header.
+func mediaPath(path string) bool {
+	return strings.HasPrefix(path, "/content/") || strings.HasPrefix(path, "/hls/") || strings.HasPrefix(path, "/api/hls/")
+}
+
+// requestToken reads the credential from the session cookie, an
+// "Authorization: Bearer" header, or, on media paths only, a "token" query
+// parameter. Query strings end up in access logs, browser history and copied
+// links, so the rest of the API does not take one.
+func requestToken(r *http.Request) string {
+	if c, err := r.Cookie(sessionCookie); err == nil && c.Value != "" {
+		return c.Value
+	}
+	if h := r.Header.Get("Authorization"); h != "" {
+		if after, ok := strings.CutPrefix(h, "Bearer "); ok {
+			return strings.TrimSpace(after)
+		}
+	}
+	if mediaPath(r.URL.Path) {
+		return r.URL.Query().Get("token")
+	}
+	return ""
+}
+
+// identity describes who made a request. Session logins carry every scope;
+// API tokens carry the scopes they were created with.
+type identity struct {
+	User    string
+	Scopes  []string
+	Session bool
+}
+
+func identityFromRequest(r *http.Request) *identity {
+	id, _ := r.Context().Value(userCtxKey).(*identity)
+	return id
+}
+
+func userFromRequest(r *http.Request) string {
+	if id := identityFromRequest(r); id != nil {
+		return id.User
+	}
+	return ""
+}
+
+func (s *Server) authEnabled() bool {
+	return s.users.HasUsers()
+}
+
+// allowed applies the access control list to the requesting user. Everything
+// is allowed while authentication is disabled.
+func (s *Server) allowed(r *http.Request, mode, rel, perm string) bool {
+	if !s.authEnabled() {
+		return true
+	}
+	return s.acl.Allowed(userFromRequest(r), mode, rel, perm)
+}
+
+// canTraverse reports whether a folder may be listed for the requesting user.
+func (s *Server) canTraverse(r *http.Request, mode, rel string) bool {
+	if !s.authEnabled() {
+		return true
+	}
+	return s.acl.CanTraverse(userFromRequest(r), mode, rel)
+}
+
+func (s *Server) authenticate(r *http.Request) (*identity, bool) {
+	secret := requestToken(r)
+	if secret == "" {
+		return nil, false
+	}
+	if token, ok := s.tokens.Lookup(secret); ok {
+		if !s.users.Exists(token.User) {
+			return nil, false
+		}
+		return &identity{User: token.User, Scopes: token.Scopes}, true
+	}
+	user, ok := s.sessions.Lookup(secret)
+	if !ok {
+		return nil, false
+	}
+	if !s.users.Exists(user) {
+		s.sessions.Revoke(secret)
+		return nil, false
+	}
+	return &identity{User: user, Scopes: auth.AllScopes, Session: true}, true
+}
+
+// requireScope wraps a handler so that API tokens lacking all of the given
+// scopes are rejected. It is a no-op while authentication is disabled.
+func (s *Server) requireScope(h http.HandlerFunc, scopes ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.authEnabled() {
+			h(w, r)
+			return
+		}
+		id := identityFromRequest(r)
+		if id == nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		for _, scope := range scopes {
+			if slices.Contains(id.Scopes, scope) {
+				h(w, r)
+				return
+			}
+		}
+		http.Error(w, "Token lacks required scope: "+strings.Join(scopes, " or "), http.StatusForbidden)
+	}
+}
+
+// withAuth requires a login session or API token on every path but the
+// login page. Authentication only turns on once the first user is created:
+// until then the server is fully open, to anyone who can reach it.
+func (s *Server) withAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.authEnabled() || isPublicPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		id, ok := s.authenticate(r)
+		if !ok {
+			if r.URL.Path == "/" {
+				http.Redirect(w, r, "/login", http.StatusFound)
+				return
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		ctx := context.WithValue(r.Context(), userCtxKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (s *Server) handleLoginPage(w http.ResponseWriter, r *http.Request) {
+	data, err := staticFiles.ReadFile("static/login.html")
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(data)
+}
+
+func (s *Server) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var creds struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+			http.Error(w, "Invalid request body", 400)
+			return
+		}
+	} else {
+		creds.Username = r.FormValue("username")
+		creds.Password = r.FormValue("password")
+	}
+
+	user, err := s.users.Authenticate(creds.Username, creds.Password)
+	if err != nil {
+		log.Printf("INFO [server] failed login user=%s remote=%s", creds.Username, r.RemoteAddr)
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+
+	token, err := s.sessions.Create(user.Name)
+	if err != nil {
+		log.Printf("ERROR [server] failed to create session: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(auth.SessionTTL / time.Second),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	log.Printf("INFO [server] login user=%s remote=%s", user.Name, r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user":  user.Name,
+		"token": token,
+	})
+}
+
+func (s *Server) HandleLogout(w http.ResponseWriter, r *http.Request) {
+	if token := requestToken(r); token != "" {
+		s.sessions.Revoke(token)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	w.WriteHeader(200)
+}
+
+func (s *Server) HandleMe(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user":        userFromRequest(r),
+		"authEnabled": s.authEnabled(),
+	})
+}
+
+type tokenInfo struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	User    string    `json:"user"`
+	Scopes  []string  `json:"scopes"`
+	Created time.Time `json:"created"`
+}
+
+// HandleTokens lets a logged-in user list (GET), create (POST) and revoke
+// (DELETE ?id=) their own API tokens. Tokens cannot be used to mint tokens.
+func (s *Server) HandleTokens(w http.ResponseWriter, r *http.Request) {
+	id := identityFromRequest(r)
+	if id == nil || !id.Session {
+		http.Error(w, "Token management requires a login session", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		list := []tokenInfo{}
+		for _, t := range s.tokens.List(id.User) {
+			list = append(list, tokenInfo{ID: t.ID, Name: t.Name, User: t.User, Scopes: t.Scopes, Created: t.Created})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+	case "POST":
+		var req struct {
+			Name   string   `json:"name"`
+			Scopes []string `json:"scopes"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", 400)
+			return
+		}
+		scopes, err := auth.ParseScopes(req.Scopes)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		t, secret, err := s.tokens.Create(id.User, req.Name, scopes)
+		if err != nil {
+			log.Printf("ERROR [server] failed to create token user=%s: %v", id.User, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("INFO [server] created token id=%s user=%s scopes=%s", t.ID, t.User, strings.Join(t.Scopes, ","))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":     t.ID,
+			"name":   t.Name,
+			"scopes": t.Scopes,
+			"token":  secret,
+		})
+
+	case "DELETE":
+		if err := s.tokens.Revoke(r.URL.Query().Get("id"), id.User); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(200)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
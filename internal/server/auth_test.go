@@ -0,0 +1,34 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		header string
+		want   string
+	}{
+		{"header", "/api/list", "Bearer secret", "secret"},
+		{"query on content", "/content/Movies/a.mkv?mode=videos&token=secret", "", "secret"},
+		{"query on hls", "/hls/v_x/index.m3u8?token=secret", "", "secret"},
+		{"query on api hls", "/api/hls/v_x/index.m3u8?token=secret", "", "secret"},
+		{"query on api", "/api/list?token=secret", "", ""},
+		{"query on tokens", "/api/tokens?token=secret", "", ""},
+		{"header wins", "/content/a.mkv?token=query", "Bearer header", "header"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.url, nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			if got := requestToken(r); got != tt.want {
+				t.Errorf("requestToken(%s) = %q, want %q", tt.url, got, tt.want)
+			}
+		})
+	}
+}
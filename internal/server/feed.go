@@ -0,0 +1,166 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tanq16/raikiri/internal/auth"
+	"github.com/tanq16/raikiri/internal/index"
+	"github.com/tanq16/raikiri/internal/media"
+)
+
+const (
+	defaultFeedLimit = 20
+	maxFeedLimit     = 200
+)
+
+// FeedItem is a listing entry decorated with the user's playback state.
+type FeedItem struct {
+	media.FileEntry
+	Position float64   `json:"position,omitempty"`
+	Duration float64   `json:"duration,omitempty"`
+	UpNext   bool      `json:"upNext,omitempty"`
+	Updated  time.Time `json:"updated"`
+}
+
+func feedLimit(r *http.Request) int {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		return defaultFeedLimit
+	}
+	return min(limit, maxFeedLimit)
+}
+
+// HandleFeedContinue lists videos the user started but did not finish, most
+// recently watched first, followed by the next episode of anything finished
+// recently (the next video in the same folder that has not been started).
+func (s *Server) HandleFeedContinue(w http.ResponseWriter, r *http.Request) {
+	mode := r.URL.Query().Get("mode")
+	library := auth.NormalizeLibrary(mode)
+	user := userFromRequest(r)
+	limit := feedLimit(r)
+
+	started := make(map[string]bool)
+	entries := s.progress.List(user, library)
+	for _, e := range entries {
+		started[e.Path] = true
+	}
+
+	items := []FeedItem{}
+	seen := make(map[string]bool)
+	add := func(item FeedItem) {
+		if len(items) < limit && !seen[item.Path] {
+			seen[item.Path] = true
+			items = append(items, item)
+		}
+	}
+
+	for _, e := range entries {
+		if e.Completed || e.Position <= 0 {
+			continue
+		}
+		if _, ok := s.resolveWithinRoot(mode, e.Path); !ok || !s.allowed(r, mode, e.Path, auth.PermRead) {
+			continue
+		}
+		entry, ok := s.libraryEntry(mode, e.Path)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		add(FeedItem{FileEntry: feedEntry(mode, entry), Position: e.Position, Duration: e.Duration, Updated: e.Updated})
+	}
+
+	for _, e := range entries {
+		if !e.Completed {
+			continue
+		}
+		next, ok := s.nextVideo(mode, e.Path)
+		if !ok || started[next.Path] || !s.allowed(r, mode, next.Path, auth.PermRead) {
+			continue
+		}
+		add(FeedItem{FileEntry: feedEntry(mode, next), UpNext: true, Updated: e.Updated})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+// feedEntry formats an entry the way recursive listings do.
+func feedEntry(mode string, e index.Entry) media.FileEntry {
+	return listEntry(mode, e.Path, path.Dir(e.Path), e)
+}
+
+// nextVideo returns the video following rel in its folder, in listing order.
+func (s *Server) nextVideo(mode, rel string) (index.Entry, bool) {
+	if _, ok := s.resolveWithinRoot(mode, rel); !ok {
+		return index.Entry{}, false
+	}
+	dir := path.Dir(rel)
+	if dir == "." {
+		dir = ""
+	}
+	files, err := s.libraryEntries(mode, dir, false)
+	if err != nil {
+		return index.Entry{}, false
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return strings.ToLower(files[i].Name) < strings.ToLower(files[j].Name)
+	})
+	passed := false
+	for _, f := range files {
+		if f.Path == rel {
+			passed = true
+			continue
+		}
+		if passed && f.Type == "video" {
+			return f, true
+		}
+	}
+	return index.Entry{}, false
+}
+
+// HandleFeedRecent lists the most recently modified media files across the
+// library. An optional type (video, audio, image) narrows the results.
+func (s *Server) HandleFeedRecent(w http.ResponseWriter, r *http.Request) {
+	mode := r.URL.Query().Get("mode")
+	wantType := r.URL.Query().Get("type")
+	if mode == "music" {
+		wantType = "audio"
+	}
+	limit := feedLimit(r)
+	found, err := s.libraryEntries(mode, "", true)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	var recent []index.Entry
+	for _, e := range found {
+		if e.Type != "audio" && e.Type != "video" && e.Type != "image" {
+			continue
+		}
+		if wantType != "" && e.Type != wantType {
+			continue
+		}
+		if !s.allowed(r, mode, e.Path, auth.PermRead) {
+			continue
+		}
+		recent = append(recent, e)
+	}
+
+	sort.Slice(recent, func(i, j int) bool { return recent[i].ModTime.After(recent[j].ModTime) })
+	if len(recent) > limit {
+		recent = recent[:limit]
+	}
+
+	items := make([]FeedItem, 0, len(recent))
+	for _, e := range recent {
+		items = append(items, FeedItem{FileEntry: feedEntry(mode, e), Updated: e.ModTime})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
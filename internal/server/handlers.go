@@ -3,13 +3,16 @@ package server
 import (
 	"encoding/json"
 	"io"
-	"io/fs"
+	"log"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
 
+	"github.com/tanq16/raikiri/internal/auth"
+	"github.com/tanq16/raikiri/internal/index"
 	"github.com/tanq16/raikiri/internal/media"
 )
 
@@ -21,6 +24,15 @@ func (s *Server) HandleContent(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+	// Folder artwork stays visible for folders the user can only pass through.
+	permitted := s.allowed(r, mode, relPath, auth.PermRead)
+	if !permitted && path.Base(relPath) == ".thumbnail.jpg" {
+		permitted = s.canTraverse(r, mode, path.Dir(relPath))
+	}
+	if !permitted {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 	http.ServeFile(w, r, fullPath)
 }
 
@@ -29,107 +41,47 @@ func (s *Server) HandleList(w http.ResponseWriter, r *http.Request) {
 	relPath := r.URL.Query().Get("path")
 	recursive := r.URL.Query().Get("recursive") == "true"
 
-	root, _ := filepath.Abs(filepath.Clean(s.getRoot(mode)))
-	targetDir, ok := s.resolveWithinRoot(mode, relPath)
-	if !ok {
+	if _, ok := s.resolveWithinRoot(mode, relPath); !ok {
 		http.Error(w, "Invalid path", 400)
 		return
 	}
+	if !s.canTraverse(r, mode, relPath) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 
-	var entries []media.FileEntry
-
-	if recursive {
-		err := filepath.WalkDir(targetDir, func(path string, d fs.DirEntry, err error) error {
-			if err != nil {
-				return nil
-			}
-			info, err := d.Info()
-			if err != nil {
-				return nil
-			}
-			name := d.Name()
-			if strings.HasPrefix(name, ".") {
-				if d.IsDir() {
-					return filepath.SkipDir
-				}
-				return nil
-			}
-
-			rel, _ := filepath.Rel(root, path)
-			rel = filepath.ToSlash(rel)
-			if rel == "." {
-				return nil
-			}
-
-			if d.IsDir() {
-				entries = append(entries, media.FileEntry{
-					Name:     name,
-					Path:     rel,
-					Type:     "folder",
-					Size:     "",
-					Thumb:    media.GetThumbnailPath(rel, name, "folder", mode),
-					Modified: media.FormatModTime(info.ModTime()),
-				})
-				return nil
-			}
-
-			fType := media.GetFileType(name, false)
-			if fType == "audio" || fType == "video" || fType == "image" {
-				dir := filepath.Dir(rel)
-				entries = append(entries, media.FileEntry{
-					Name:     name,
-					Path:     rel,
-					Type:     fType,
-					Size:     media.FormatFileSize(info.Size()),
-					Thumb:    media.GetThumbnailPath(dir, name, fType, mode),
-					Modified: media.FormatModTime(info.ModTime()),
-				})
-			}
-			return nil
-		})
-		if err != nil {
-			http.Error(w, err.Error(), 500)
-			return
-		}
-	} else {
-		files, err := os.ReadDir(targetDir)
-		if err != nil {
-			http.Error(w, err.Error(), 500)
-			return
-		}
+	found, err := s.libraryEntries(mode, cleanRelPath(relPath), recursive)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
 
-		for _, f := range files {
-			if strings.HasPrefix(f.Name(), ".") {
+	var entries []media.FileEntry
+	for _, e := range found {
+		if e.IsDir() {
+			if !s.canTraverse(r, mode, e.Path) {
 				continue
 			}
-
-			info, err := f.Info()
-			if err != nil {
+		} else {
+			if !s.allowed(r, mode, e.Path, auth.PermRead) {
 				continue
 			}
-			size := ""
-			if !f.IsDir() {
-				size = media.FormatFileSize(info.Size())
-			}
-
-			fType := media.GetFileType(f.Name(), f.IsDir())
-
-			fullRelPath := filepath.Join(relPath, f.Name())
-			fullRelPath = filepath.ToSlash(fullRelPath)
-			thumbBasePath := relPath
-			if fType == "folder" {
-				thumbBasePath = fullRelPath
+			if recursive && e.Type != "audio" && e.Type != "video" && e.Type != "image" {
+				continue
 			}
+		}
 
-			entries = append(entries, media.FileEntry{
-				Name:     f.Name(),
-				Path:     fullRelPath,
-				Type:     fType,
-				Size:     size,
-				Thumb:    media.GetThumbnailPath(thumbBasePath, f.Name(), fType, mode),
-				Modified: media.FormatModTime(info.ModTime()),
-			})
+		// Non-recursive listings report paths joined onto the requested path,
+		// recursive ones relative to the library root.
+		entryPath, thumbBase := e.Path, path.Dir(e.Path)
+		if !recursive {
+			entryPath = filepath.ToSlash(filepath.Join(relPath, e.Name))
+			thumbBase = relPath
+		}
+		if e.IsDir() {
+			thumbBase = entryPath
 		}
+		entries = append(entries, listEntry(mode, entryPath, thumbBase, e))
 	}
 
 	sort.Slice(entries, func(i, j int) bool {
@@ -146,6 +98,24 @@ func (s *Server) HandleList(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(entries)
 }
 
+// listEntry converts an index entry into the listing format clients expect.
+func listEntry(mode, entryPath, thumbBase string, e index.Entry) media.FileEntry {
+	entry := media.FileEntry{
+		Name:     e.Name,
+		Path:     entryPath,
+		Type:     e.Type,
+		Thumb:    media.GetThumbnailPath(thumbBase, e.Name, e.Type, mode),
+		Modified: media.FormatModTime(e.ModTime),
+	}
+	if !e.IsDir() {
+		entry.Size = media.FormatFileSize(e.Size)
+	}
+	if e.Media != nil {
+		entry.Duration = e.Media.Duration
+	}
+	return entry
+}
+
 func (s *Server) HandleUpload(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -161,6 +131,11 @@ func (s *Server) HandleUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !s.allowed(r, mode, relPath, auth.PermUpload) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
 	files := r.MultipartForm.File["files"]
 	for _, fileHeader := range files {
 		file, err := fileHeader.Open()
@@ -191,6 +166,10 @@ func (s *Server) HandleUpload(w http.ResponseWriter, r *http.Request) {
 
 		file.Close()
 		dst.Close()
+		rel := cleanRelPath(filepath.Join(relPath, fileHeader.Filename))
+		if err := s.index.Update(auth.NormalizeLibrary(mode), s.libraryRoot(mode), rel); err != nil {
+			log.Printf("WARN [server] failed to index upload path=%s: %v", rel, err)
+		}
 	}
 
 	w.WriteHeader(200)
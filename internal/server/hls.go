@@ -0,0 +1,539 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// hlsSegmentLength is the length in seconds of transcoded segments, which
+	// get a forced keyframe at every boundary.
+	hlsSegmentLength = 6
+	// A request this many segments past what ffmpeg has written waits for it
+	// instead of restarting ffmpeg at the requested segment.
+	segmentLookahead = 3
+	// How long a segment request waits for ffmpeg before giving up.
+	segmentWait = 30 * time.Second
+	// A rendition the player has not asked anything of for this long while
+	// it fetches another one has its ffmpeg stopped.
+	variantIdle = 20 * time.Second
+	// ffmpeg writes its own playlist here; it only tells which segments are
+	// finished; players get the VOD playlist written up front.
+	ffmpegPlaylist = "ffmpeg.m3u8"
+)
+
+var errSegmentRange = errors.New("segment out of range")
+
+// hlsSession produces the segments of one HLS stream. With a segment plan
+// (starts), the complete VOD playlist is written up front and ffmpeg is
+// started at whichever segment a player asks for when no job is about to
+// write it anyway, so seeking anywhere is quick. Viewers watching close
+// together share a job, which runs until none of them uses it any more,
+// and each viewer uses at most one; finished segments are kept for
+// everyone. Without a plan, ffmpeg writes a growing event
+// playlist from offset and nothing is restarted.
+type hlsSession struct {
+	id        string
+	dir       string
+	input     string
+	codecArgs []string
+	// transcode is set when video is re-encoded rather than copied; a
+	// keyframe is then forced at every segment start. grid marks plans of
+	// fixed-length segments, whose keyframes are given as an expression
+	// rather than a list of times.
+	transcode bool
+	grid      bool
+	ts        bool
+	// audio marks audio-only renditions. Audio can be cut anywhere, so the
+	// muxer cuts it on the grid itself.
+	audio    bool
+	starts   []float64
+	duration float64
+	offset   float64
+
+	mu       sync.Mutex
+	jobs     []*hlsJob
+	lastJob  int // numbers the work directories of jobs
+	complete map[int]bool
+}
+
+// hlsJob is one ffmpeg run writing segments from start onwards. VOD jobs
+// write into a directory of their own and finished segments are moved into
+// the session directory, so players only ever see whole segments.
+type hlsJob struct {
+	dir    string
+	cmd    *exec.Cmd
+	exited chan struct{}
+	start  int
+	next   int // first segment the job has not finished
+	// viewers maps the viewers using the job to when they last did.
+	viewers map[string]time.Time
+}
+
+func (j *hlsJob) exitedLocked() bool {
+	select {
+	case <-j.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+// vod reports whether the session serves a pre-built VOD playlist.
+func (h *hlsSession) vod() bool {
+	return h.starts != nil
+}
+
+func (h *hlsSession) ext() string {
+	if h.ts {
+		return "ts"
+	}
+	return "m4s"
+}
+
+func (h *hlsSession) segmentName(n int) string {
+	return fmt.Sprintf("seg_%d.%s", n, h.ext())
+}
+
+// parseSegment returns the segment number of a file name such as seg_12.m4s.
+func (h *hlsSession) parseSegment(name string) (int, bool) {
+	var n int
+	var ext string
+	if _, err := fmt.Sscanf(strings.Replace(name, ".", " ", 1), "seg_%d %s", &n, &ext); err != nil || ext != h.ext() {
+		return 0, false
+	}
+	return n, true
+}
+
+// segmentAt returns the segment that contains time t.
+func (h *hlsSession) segmentAt(t float64) int {
+	return max(sort.Search(len(h.starts), func(i int) bool { return h.starts[i] > t })-1, 0)
+}
+
+// gridSegments splits duration into fixed-length segments.
+func gridSegments(duration float64) []float64 {
+	n := max(int(math.Ceil(duration/hlsSegmentLength)), 1)
+	starts := make([]float64, n)
+	for i := range starts {
+		starts[i] = float64(i * hlsSegmentLength)
+	}
+	return starts
+}
+
+// keyframeSegments starts a segment at every keyframe, the only places
+// copied video can be cut. Returns nil when keyframes are too dense to make
+// sensible segments.
+func keyframeSegments(keyframes []float64, startTime, duration float64) []float64 {
+	starts := []float64{0}
+	for _, kf := range keyframes {
+		t := kf - startTime
+		if t <= starts[len(starts)-1] {
+			continue
+		}
+		if t >= duration-0.5 {
+			break
+		}
+		if t-starts[len(starts)-1] < 0.5 {
+			return nil
+		}
+		starts = append(starts, t)
+	}
+	return starts
+}
+
+// writePlaylist writes the VOD playlist the player loads.
+func (h *hlsSession) writePlaylist() error {
+	var b strings.Builder
+	target := 0.0
+	for i := range h.starts {
+		target = max(target, h.segmentDuration(i))
+	}
+	version := 7
+	if h.ts {
+		version = 3
+	}
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:%d\n#EXT-X-TARGETDURATION:%d\n", version, int(math.Ceil(target)))
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-INDEPENDENT-SEGMENTS\n")
+	if !h.ts {
+		b.WriteString("#EXT-X-MAP:URI=\"init.mp4\"\n")
+	}
+	for i := range h.starts {
+		fmt.Fprintf(&b, "#EXTINF:%.6f,\n%s\n", h.segmentDuration(i), h.segmentName(i))
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+	return os.WriteFile(filepath.Join(h.dir, "index.m3u8"), []byte(b.String()), 0644)
+}
+
+func (h *hlsSession) segmentDuration(i int) float64 {
+	if i+1 < len(h.starts) {
+		return h.starts[i+1] - h.starts[i]
+	}
+	return max(h.duration-h.starts[i], 0.1)
+}
+
+// ffmpegArgs builds the command that writes segments from segment onwards
+// into dir. VOD sessions keep source timestamps (-copyts), so a segment
+// carries the same times whichever run produced it.
+func (h *hlsSession) ffmpegArgs(segment int, dir string) []string {
+	args := []string{"-loglevel", "warning"}
+	var start float64
+	switch {
+	case h.vod():
+		start = h.starts[segment]
+		if segment > 0 {
+			// Nudge past the keyframe so rounding cannot land on the one before.
+			args = append(args, "-ss", fmt.Sprintf("%.3f", start+0.01))
+		}
+		args = append(args, "-copyts", "-start_at_zero")
+	case h.offset > 0:
+		// Output timestamps begin at zero at the resume point, and the client
+		// adds startOffset back to its clock.
+		args = append(args, "-start_at_zero", "-ss", fmt.Sprintf("%.3f", h.offset))
+	default:
+		args = append(args, "-start_at_zero")
+	}
+	args = append(args, "-i", h.input)
+	args = append(args, h.codecArgs...)
+	if h.vod() && h.transcode {
+		// Keyframes only at segment starts, so every cut lands on one.
+		forced := fmt.Sprintf("expr:gte(t,%.3f+n_forced*%d)", start, hlsSegmentLength)
+		if !h.grid {
+			times := make([]string, 0, len(h.starts)-segment)
+			for _, t := range h.starts[segment:] {
+				times = append(times, fmt.Sprintf("%.3f", t))
+			}
+			forced = strings.Join(times, ",")
+		}
+		args = append(args,
+			"-force_key_frames", forced,
+			"-x264-params", "keyint=infinite:scenecut=0",
+		)
+	}
+
+	playlist := "index.m3u8"
+	if h.vod() {
+		// A tiny target makes the muxer cut at every keyframe, which the
+		// plan already accounts for.
+		playlist = ffmpegPlaylist
+		hlsTime := "0.1"
+		if h.audio {
+			hlsTime = fmt.Sprint(hlsSegmentLength)
+		}
+		args = append(args,
+			"-avoid_negative_ts", "disabled",
+			"-max_interleave_delta", "0",
+			"-max_muxing_queue_size", "4096",
+			"-f", "hls",
+			"-hls_time", hlsTime,
+			"-start_number", fmt.Sprint(segment),
+		)
+	} else {
+		args = append(args,
+			"-avoid_negative_ts", "make_zero",
+			"-max_interleave_delta", "0",
+			"-max_muxing_queue_size", "4096",
+			"-f", "hls",
+			"-hls_time", fmt.Sprint(hlsSegmentLength),
+			"-hls_playlist_type", "event",
+		)
+	}
+	args = append(args, "-hls_list_size", "0")
+	if h.ts {
+		args = append(args, "-hls_segment_type", "mpegts")
+	} else {
+		args = append(args, "-hls_segment_type", "fmp4", "-hls_fmp4_init_filename", "init.mp4")
+	}
+	args = append(args,
+		"-hls_segment_filename", filepath.Join(dir, "seg_%d."+h.ext()),
+		filepath.Join(dir, playlist),
+	)
+	return args
+}
+
+// load picks up the segments an earlier playback of the same stream left
+// in its directory, dropping the work directories of jobs that were still
+// running when it ended.
+func (h *hlsSession) load() error {
+	entries, err := os.ReadDir(h.dir)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, e := range entries {
+		if e.IsDir() && strings.HasPrefix(e.Name(), "job_") {
+			os.RemoveAll(filepath.Join(h.dir, e.Name()))
+		} else if n, ok := h.parseSegment(e.Name()); ok && n < len(h.starts) {
+			h.complete[n] = true
+		}
+	}
+	return nil
+}
+
+// startLocked starts a job for viewer at segment, releasing the one it
+// used so far; h.mu must be held. Event playlists are written in place, as
+// players read them while they grow.
+func (h *hlsSession) startLocked(viewer string, segment int) error {
+	h.releaseLocked(viewer)
+	dir := h.dir
+	if h.vod() {
+		h.lastJob++
+		dir = filepath.Join(h.dir, fmt.Sprintf("job_%d", h.lastJob))
+		os.RemoveAll(dir)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create job directory: %w", err)
+		}
+	}
+	cmd := exec.Command("ffmpeg", h.ffmpegArgs(segment, dir)...)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+	h.jobs = append(h.jobs, &hlsJob{dir: dir, cmd: cmd, exited: exited, start: segment, next: segment,
+		viewers: map[string]time.Time{viewer: time.Now()}})
+	return nil
+}
+
+// useLocked records that viewer is using job, releasing any other job it
+// used; h.mu must be held.
+func (h *hlsSession) useLocked(viewer string, job *hlsJob) {
+	if _, ok := job.viewers[viewer]; !ok {
+		h.releaseLocked(viewer)
+	}
+	job.viewers[viewer] = time.Now()
+}
+
+// jobOfLocked returns the job viewer uses, or nil; h.mu must be held.
+func (h *hlsSession) jobOfLocked(viewer string) *hlsJob {
+	for _, job := range h.jobs {
+		if _, ok := job.viewers[viewer]; ok {
+			return job
+		}
+	}
+	return nil
+}
+
+// releaseLocked detaches viewer from the job it uses, stopping the job when
+// no other viewer uses it; h.mu must be held.
+func (h *hlsSession) releaseLocked(viewer string) {
+	job := h.jobOfLocked(viewer)
+	if job == nil {
+		return
+	}
+	delete(job.viewers, viewer)
+	if len(job.viewers) == 0 {
+		h.stopJobLocked(job)
+	}
+}
+
+// stopJobLocked kills a job, keeping the segments it finished; h.mu must be
+// held.
+func (h *hlsSession) stopJobLocked(job *hlsJob) {
+	job.cmd.Process.Kill()
+	<-job.exited
+	h.refreshJobLocked(job)
+	if h.vod() {
+		os.RemoveAll(job.dir)
+	}
+	h.jobs = slices.DeleteFunc(h.jobs, func(j *hlsJob) bool { return j == job })
+}
+
+// stopViewer releases the job of a viewer that left.
+func (h *hlsSession) stopViewer(viewer string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.releaseLocked(viewer)
+}
+
+// terminate asks every job to finish up and exit, falling back to killing
+// them where interrupts are not supported. It returns the channels closed as
+// they exit.
+func (h *hlsSession) terminate() []<-chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var exited []<-chan struct{}
+	for _, job := range h.jobs {
+		if err := job.cmd.Process.Signal(os.Interrupt); err != nil {
+			job.cmd.Process.Kill()
+		}
+		exited = append(exited, job.exited)
+	}
+	return exited
+}
+
+func (h *hlsSession) stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for len(h.jobs) > 0 {
+		h.stopJobLocked(h.jobs[0])
+	}
+}
+
+// refreshLocked collects what every job has finished and stops jobs that
+// ran into segments another job already wrote.
+func (h *hlsSession) refreshLocked() {
+	for _, job := range slices.Clone(h.jobs) {
+		if h.refreshJobLocked(job) {
+			log.Printf("INFO [server] HLS job caught up with existing segments session=%s segment=%d", h.id, job.next)
+			h.stopJobLocked(job)
+		}
+	}
+}
+
+// refreshJobLocked marks the segments listed in the job's playlist as
+// complete, moving them into the session directory; ffmpeg only lists a
+// segment once it is fully written. It reports whether the job wrote a
+// segment that was already complete.
+func (h *hlsSession) refreshJobLocked(job *hlsJob) (redundant bool) {
+	name := ffmpegPlaylist
+	if !h.vod() {
+		name = "index.m3u8"
+	}
+	f, err := os.Open(filepath.Join(job.dir, name))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		n, ok := h.parseSegment(filepath.Base(line))
+		if !ok {
+			continue
+		}
+		if h.vod() && n >= job.next {
+			if !h.ts {
+				// The init segment is complete once a media segment is.
+				dst := filepath.Join(h.dir, "init.mp4")
+				if _, err := os.Stat(dst); err != nil {
+					os.Rename(filepath.Join(job.dir, "init.mp4"), dst)
+				}
+			}
+			src := filepath.Join(job.dir, h.segmentName(n))
+			if h.complete[n] {
+				os.Remove(src)
+				redundant = true
+			} else if os.Rename(src, filepath.Join(h.dir, h.segmentName(n))) == nil {
+				h.complete[n] = true
+			}
+		} else if !h.vod() {
+			h.complete[n] = true
+		}
+		job.next = max(job.next, n+1)
+	}
+	return redundant
+}
+
+// producedLocked returns the first segment from the job's position on that
+// is not finished.
+func (h *hlsSession) producedLocked(job *hlsJob) int {
+	n := job.next
+	for h.complete[n] {
+		n++
+	}
+	return n
+}
+
+// coveringLocked returns the job that is going to write segment n soon, or
+// nil if none is.
+func (h *hlsSession) coveringLocked(n int) *hlsJob {
+	var found *hlsJob
+	for _, job := range h.jobs {
+		if h.vod() && (n < job.start || n > h.producedLocked(job)+segmentLookahead) {
+			continue
+		}
+		if found == nil || !job.exitedLocked() {
+			found = job
+		}
+	}
+	return found
+}
+
+// waitSegment blocks until segment n is fully written, having viewer use
+// the job that is going to reach it soon or starting one at n when none is.
+func (h *hlsSession) waitSegment(viewer string, n int, timeout time.Duration) error {
+	if n < 0 || (h.vod() && n >= len(h.starts)) {
+		return errSegmentRange
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		h.mu.Lock()
+		h.refreshLocked()
+		if h.complete[n] {
+			h.mu.Unlock()
+			return nil
+		}
+		job := h.coveringLocked(n)
+		switch {
+		case job == nil && h.vod():
+			log.Printf("INFO [server] HLS starting ffmpeg session=%s viewer=%s segment=%d", h.id, viewer, n)
+			if err := h.startLocked(viewer, n); err != nil {
+				h.mu.Unlock()
+				return err
+			}
+		case job == nil || job.exitedLocked():
+			h.mu.Unlock()
+			return fmt.Errorf("ffmpeg exited before writing segment %d", n)
+		default:
+			h.useLocked(viewer, job)
+		}
+		h.mu.Unlock()
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for segment %d", n)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// waitInit blocks until the fMP4 init segment exists, starting a job for
+// viewer at the beginning if nothing is running yet.
+func (h *hlsSession) waitInit(viewer string, timeout time.Duration) error {
+	path := filepath.Join(h.dir, "init.mp4")
+	deadline := time.Now().Add(timeout)
+	for {
+		h.mu.Lock()
+		h.refreshLocked()
+		if info, err := os.Stat(path); err == nil && info.Size() > 0 {
+			h.mu.Unlock()
+			return nil
+		}
+		var err error
+		running := false
+		for _, job := range h.jobs {
+			running = running || !job.exitedLocked()
+		}
+		switch {
+		case len(h.jobs) == 0:
+			err = h.startLocked(viewer, 0)
+		case !running:
+			err = errors.New("ffmpeg exited before writing the init segment")
+		}
+		h.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for the init segment")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Weights of streams against the transcode limit: copying video costs a
+// fraction of encoding it.
+const (
+	transcodeWeight = 4
+	copyWeight      = 1
+)
+
+const (
+	// How long a stream request waits in the queue for capacity to free up.
+	queueWait = 15 * time.Second
+	// Stream requests beyond this many waiting are turned away at once.
+	maxQueued = 8
+	// Retry hints sent with rejected stream requests.
+	busyRetryAfter = 30 * time.Second
+	userRetryAfter = 10 * time.Second
+)
+
+var (
+	errUserLimit = errors.New("too many streams for this user")
+	errQueueFull = errors.New("too many streams are waiting")
+	errBusy      = errors.New("timed out waiting for a free transcode slot")
+)
+
+// streamSlot is the capacity held by one running stream, however many
+// viewers share it.
+type streamSlot struct {
+	weight int
+}
+
+type slotWaiter struct {
+	slot  *streamSlot
+	ready chan struct{}
+}
+
+// transcodeLimiter bounds the ffmpeg work running at once. Capacity is in
+// weight units, transcodeWeight per transcoding stream, and is held by the
+// stream rather than its viewers, as viewers of a shared stream share its
+// ffmpeg; requests that do not fit wait in a first-come first-served queue.
+// Each user can also be held to a number of streams, counted per viewer.
+// Zero disables either limit.
+type transcodeLimiter struct {
+	mu       sync.Mutex
+	capacity int
+	perUser  int
+	used     int
+	users    map[string]int
+	queue    []*slotWaiter
+}
+
+func newTranscodeLimiter(maxTranscodes, perUser int) *transcodeLimiter {
+	return &transcodeLimiter{
+		capacity: maxTranscodes * transcodeWeight,
+		perUser:  perUser,
+		users:    make(map[string]int),
+	}
+}
+
+// fitsLocked reports whether weight more units can run now; l.mu must be
+// held. A stream heavier than the whole capacity still runs on an idle
+// server rather than never.
+func (l *transcodeLimiter) fitsLocked(weight int) bool {
+	return l.capacity <= 0 || l.used == 0 || l.used+weight <= l.capacity
+}
+
+// join counts a stream viewer of user against the per-user limit. user is
+// empty when authentication is off, which exempts it.
+func (l *transcodeLimiter) join(user string) error {
+	if user == "" {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.perUser > 0 && l.users[user] >= l.perUser {
+		return errUserLimit
+	}
+	l.users[user]++
+	return nil
+}
+
+// leave hands back what join counted.
+func (l *transcodeLimiter) leave(user string) {
+	if user == "" {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.users[user]--; l.users[user] <= 0 {
+		delete(l.users, user)
+	}
+}
+
+// acquire reserves capacity for a stream, waiting up to wait behind earlier
+// requests.
+func (l *transcodeLimiter) acquire(ctx context.Context, weight int, wait time.Duration) (*streamSlot, error) {
+	slot := &streamSlot{weight: weight}
+	l.mu.Lock()
+	if len(l.queue) == 0 && l.fitsLocked(weight) {
+		l.used += weight
+		l.mu.Unlock()
+		return slot, nil
+	}
+	if len(l.queue) >= maxQueued {
+		l.mu.Unlock()
+		return nil, errQueueFull
+	}
+	waiter := &slotWaiter{slot: slot, ready: make(chan struct{})}
+	l.queue = append(l.queue, waiter)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-waiter.ready:
+		return slot, nil
+	case <-timer.C:
+		err = errBusy
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-waiter.ready:
+		// Granted while giving up; hand the capacity back.
+		l.releaseLocked(slot)
+	default:
+		l.queue = slices.DeleteFunc(l.queue, func(w *slotWaiter) bool { return w == waiter })
+		l.promoteLocked()
+	}
+	return nil, err
+}
+
+func (l *transcodeLimiter) release(slot *streamSlot) {
+	if slot == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.releaseLocked(slot)
+}
+
+func (l *transcodeLimiter) releaseLocked(slot *streamSlot) {
+	l.used -= slot.weight
+	l.promoteLocked()
+}
+
+// promoteLocked starts queued streams in order for as long as they fit.
+func (l *transcodeLimiter) promoteLocked() {
+	for len(l.queue) > 0 && l.fitsLocked(l.queue[0].slot.weight) {
+		w := l.queue[0]
+		l.queue = l.queue[1:]
+		l.used += w.slot.weight
+		close(w.ready)
+	}
+}
+
+// isLimitError reports whether err is a stream turned away by the limiter
+// rather than one that failed.
+func isLimitError(err error) bool {
+	return errors.Is(err, errUserLimit) || errors.Is(err, errQueueFull) || errors.Is(err, errBusy) || errors.Is(err, context.Canceled)
+}
+
+// writeLimitError turns a rejected stream into a response the client can act
+// on: 429 when the user is at their own limit, 503 while the server is busy,
+// both with a Retry-After hint.
+func writeLimitError(w http.ResponseWriter, err error) {
+	status, retry := http.StatusServiceUnavailable, busyRetryAfter
+	switch {
+	case errors.Is(err, errUserLimit):
+		status, retry = http.StatusTooManyRequests, userRetryAfter
+	case errors.Is(err, context.Canceled):
+		return // the client went away while queued
+	}
+	log.Printf("INFO [server] stream rejected: %v", err)
+	w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())))
+	http.Error(w, "Stream rejected: "+err.Error()+"; try again later", status)
+}
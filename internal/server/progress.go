@@ -0,0 +1,112 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/tanq16/raikiri/internal/auth"
+	"github.com/tanq16/raikiri/internal/progress"
+)
+
+// HandleProgress reads and records watch progress for the requesting user.
+//
+//	GET    ?mode=&path=   single entry (position 0 if never played)
+//	GET    ?mode=         all entries, most recent first
+//	PUT    {mode, path, position, duration, completed}
+//	DELETE ?mode=&path=   forget an entry
+//
+// POST is accepted like PUT so navigator.sendBeacon can flush progress when a
+// tab closes.
+func (s *Server) HandleProgress(w http.ResponseWriter, r *http.Request) {
+	user := userFromRequest(r)
+
+	switch r.Method {
+	case "GET":
+		mode := r.URL.Query().Get("mode")
+		rel := r.URL.Query().Get("path")
+		w.Header().Set("Content-Type", "application/json")
+		if rel == "" {
+			library := ""
+			if mode != "" {
+				library = auth.NormalizeLibrary(mode)
+			}
+			list := []progress.Entry{}
+			for _, e := range s.progress.List(user, library) {
+				if s.allowed(r, e.Library, e.Path, auth.PermRead) {
+					list = append(list, e)
+				}
+			}
+			json.NewEncoder(w).Encode(list)
+			return
+		}
+		entry, _ := s.progress.Get(user, auth.NormalizeLibrary(mode), cleanRelPath(rel))
+		json.NewEncoder(w).Encode(entry)
+
+	case "PUT", "POST":
+		var req struct {
+			Mode      string  `json:"mode"`
+			Path      string  `json:"path"`
+			Position  float64 `json:"position"`
+			Duration  float64 `json:"duration"`
+			Completed bool    `json:"completed"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", 400)
+			return
+		}
+		rel := cleanRelPath(req.Path)
+		if rel == "" {
+			http.Error(w, "Missing path", 400)
+			return
+		}
+		full, ok := s.resolveWithinRoot(req.Mode, rel)
+		if !ok {
+			http.Error(w, "Invalid path", 400)
+			return
+		}
+		if info, err := os.Stat(full); err != nil || info.IsDir() {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+		if !s.allowed(r, req.Mode, rel, auth.PermRead) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		entry, err := s.progress.Update(user, progress.Entry{
+			Library:   auth.NormalizeLibrary(req.Mode),
+			Path:      rel,
+			Position:  req.Position,
+			Duration:  req.Duration,
+			Completed: req.Completed,
+		})
+		if err != nil {
+			log.Printf("ERROR [server] failed to save progress user=%s path=%s: %v", user, rel, err)
+			http.Error(w, "Failed to save progress", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entry)
+
+	case "DELETE":
+		mode := r.URL.Query().Get("mode")
+		rel := cleanRelPath(r.URL.Query().Get("path"))
+		if err := s.progress.Delete(user, auth.NormalizeLibrary(mode), rel); err != nil {
+			log.Printf("ERROR [server] failed to delete progress user=%s path=%s: %v", user, rel, err)
+			http.Error(w, "Failed to delete progress", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(200)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// cleanRelPath normalizes a library-relative path the way listings report it.
+func cleanRelPath(rel string) string {
+	return strings.TrimPrefix(path.Clean("/"+rel), "/")
+}
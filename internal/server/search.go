@@ -0,0 +1,218 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tanq16/raikiri/internal/auth"
+	"github.com/tanq16/raikiri/internal/index"
+	"github.com/tanq16/raikiri/internal/media"
+	"github.com/tanq16/raikiri/internal/search"
+)
+
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 200
+	// Matching lines returned per video by quote searches.
+	maxQuoteMatches = 20
+)
+
+type SearchResponse struct {
+	Query   string            `json:"query"`
+	Total   int               `json:"total"`
+	Offset  int               `json:"offset"`
+	Limit   int               `json:"limit"`
+	Results []media.FileEntry `json:"results"`
+}
+
+// QuoteResult is a video whose subtitles match a quote search, with the
+// matching lines best first, then in time order.
+type QuoteResult struct {
+	media.FileEntry
+	Matches []index.Quote `json:"matches"`
+}
+
+type QuoteSearchResponse struct {
+	Query   string        `json:"query"`
+	Total   int           `json:"total"`
+	Offset  int           `json:"offset"`
+	Limit   int           `json:"limit"`
+	Results []QuoteResult `json:"results"`
+}
+
+// HandleSearch ranks library entries beneath path (the whole library by
+// default) against q, matching every query word against the file name or
+// its folders with typo tolerance. type narrows results to a comma-separated
+// list of entry types; offset and limit page through them.
+func (s *Server) HandleSearch(w http.ResponseWriter, r *http.Request) {
+	mode := r.URL.Query().Get("mode")
+	relPath := r.URL.Query().Get("path")
+	query := search.Parse(r.URL.Query().Get("q"))
+	if query.Empty() {
+		http.Error(w, "Missing query", 400)
+		return
+	}
+	if _, ok := s.resolveWithinRoot(mode, relPath); !ok {
+		http.Error(w, "Invalid path", 400)
+		return
+	}
+	if !s.canTraverse(r, mode, relPath) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var types []string
+	for t := range strings.SplitSeq(r.URL.Query().Get("type"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+	if mode == "music" && len(types) == 0 {
+		types = []string{"folder", "audio"}
+	}
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	offset = max(offset, 0)
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	limit = min(limit, maxSearchLimit)
+
+	found, err := s.libraryEntries(mode, cleanRelPath(relPath), true)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	type hit struct {
+		entry media.FileEntry
+		score float64
+	}
+	var hits []hit
+	for _, e := range found {
+		if len(types) > 0 && !slices.Contains(types, e.Type) {
+			continue
+		}
+		score := search.Score(query, e.Path, e.IsDir())
+		if score <= 0 {
+			continue
+		}
+		if e.IsDir() {
+			if !s.canTraverse(r, mode, e.Path) {
+				continue
+			}
+		} else if !s.allowed(r, mode, e.Path, auth.PermRead) {
+			continue
+		}
+		hits = append(hits, hit{entry: feedEntry(mode, e), score: score})
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].score != hits[j].score {
+			return hits[i].score > hits[j].score
+		}
+		return strings.ToLower(hits[i].entry.Path) < strings.ToLower(hits[j].entry.Path)
+	})
+
+	resp := SearchResponse{
+		Query:   r.URL.Query().Get("q"),
+		Total:   len(hits),
+		Offset:  offset,
+		Limit:   limit,
+		Results: []media.FileEntry{},
+	}
+	for i := offset; i < len(hits) && i < offset+limit; i++ {
+		resp.Results = append(resp.Results, hits[i].entry)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// HandleSearchQuotes finds videos beneath path (the whole library by
+// default) with a subtitle line containing every word of q, from the
+// dialogue the library index reads in the background. Videos with the
+// words in order on one line come first; offset and limit page through
+// them.
+func (s *Server) HandleSearchQuotes(w http.ResponseWriter, r *http.Request) {
+	mode := r.URL.Query().Get("mode")
+	relPath := cleanRelPath(r.URL.Query().Get("path"))
+	query := search.Parse(r.URL.Query().Get("q"))
+	if query.Empty() {
+		http.Error(w, "Missing query", 400)
+		return
+	}
+	if _, ok := s.resolveWithinRoot(mode, relPath); !ok {
+		http.Error(w, "Invalid path", 400)
+		return
+	}
+	if !s.canTraverse(r, mode, relPath) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	offset = max(offset, 0)
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	limit = min(limit, maxSearchLimit)
+
+	type hit struct {
+		result QuoteResult
+		score  float64
+	}
+	var hits []hit
+	for _, f := range s.index.SearchQuotes(s.libraryRoot(mode), query.Terms) {
+		if relPath != "" && !strings.HasPrefix(f.Path, relPath+"/") {
+			continue
+		}
+		if !s.allowed(r, mode, f.Path, auth.PermRead) {
+			continue
+		}
+		type match struct {
+			quote index.Quote
+			score float64
+		}
+		var found []match
+		for _, q := range f.Quotes {
+			if score := search.MatchText(query, q.Text); score > 0 {
+				found = append(found, match{quote: q, score: score})
+			}
+		}
+		if len(found) == 0 {
+			continue
+		}
+		e, ok := s.libraryEntry(mode, f.Path)
+		if !ok {
+			continue
+		}
+		sort.SliceStable(found, func(i, j int) bool { return found[i].score > found[j].score })
+		matches := make([]index.Quote, 0, min(len(found), maxQuoteMatches))
+		for _, m := range found[:min(len(found), maxQuoteMatches)] {
+			matches = append(matches, m.quote)
+		}
+		hits = append(hits, hit{result: QuoteResult{FileEntry: feedEntry(mode, e), Matches: matches}, score: found[0].score})
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].score != hits[j].score {
+			return hits[i].score > hits[j].score
+		}
+		return strings.ToLower(hits[i].result.Path) < strings.ToLower(hits[j].result.Path)
+	})
+
+	resp := QuoteSearchResponse{
+		Query:   r.URL.Query().Get("q"),
+		Total:   len(hits),
+		Offset:  offset,
+		Limit:   limit,
+		Results: []QuoteResult{},
+	}
+	for i := offset; i < len(hits) && i < offset+limit; i++ {
+		resp.Results = append(resp.Results, hits[i].result)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
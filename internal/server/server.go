@@ -10,10 +10,16 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/tanq16/raikiri/internal/auth"
+	"github.com/tanq16/raikiri/internal/cache"
+	"github.com/tanq16/raikiri/internal/index"
+	"github.com/tanq16/raikiri/internal/media"
+	"github.com/tanq16/raikiri/internal/progress"
+	"github.com/tanq16/raikiri/internal/subsync"
 )
 
 //go:embed static
@@ -24,24 +30,59 @@ type Config struct {
 	MediaPath string
 	MusicPath string
 	CachePath string
+	DataPath  string
+	// RescanInterval is how often the library index is rebuilt from disk on
+	// top of filesystem watching; zero disables periodic rescans.
+	RescanInterval time.Duration
+	// Ladder lists the renditions offered as an adaptive stream (source
+	// "hls-abr") besides the original; empty disables adaptive streaming.
+	Ladder []Rendition
+	// MaxTranscodes caps concurrent transcodes, with a remux counting as a
+	// quarter of one; zero means no limit.
+	MaxTranscodes int
+	// MaxUserStreams caps the HLS streams one user runs at once; zero means
+	// no limit. It only applies with authentication enabled.
+	MaxUserStreams int
+	// StreamIdleTimeout is how long a stream runs without segment fetches or
+	// heartbeats before it is stopped; zero keeps streams until stopped.
+	StreamIdleTimeout time.Duration
+	// CachePolicy bounds the size and age of the cache, which is checked
+	// every CacheInterval (zero disables cleanup).
+	CachePolicy   cache.Policy
+	CacheInterval time.Duration
+	// SubtitleCharset is what external subtitle files that are not UTF-8
+	// are read as; empty has it guessed.
+	SubtitleCharset string
 }
 
 type Server struct {
-	config       Config
-	mux          *http.ServeMux
-	activeStreams map[string]*exec.Cmd
-	streamMutex  sync.Mutex
+	config          Config
+	mux             *http.ServeMux
+	activeStreams   map[string]*streamSession
+	viewers         map[string]*viewer
+	streamMutex     sync.Mutex
+	limiter         *transcodeLimiter
 	ffmpegAvailable bool
+	users           *auth.Store
+	sessions        *auth.Sessions
+	tokens          *auth.TokenStore
+	acl             *auth.ACL
+	progress        *progress.Store
+	subtitleSync    *subsync.Store
+	index           *index.Index
 }
 
 func New(cfg Config) *Server {
 	_, ffmpegErr := exec.LookPath("ffmpeg")
 	_, ffprobeErr := exec.LookPath("ffprobe")
 	return &Server{
-		config:       cfg,
-		mux:          http.NewServeMux(),
-		activeStreams: make(map[string]*exec.Cmd),
+		config:          cfg,
+		mux:             http.NewServeMux(),
+		activeStreams:   make(map[string]*streamSession),
+		viewers:         make(map[string]*viewer),
+		limiter:         newTranscodeLimiter(cfg.MaxTranscodes, cfg.MaxUserStreams),
 		ffmpegAvailable: ffmpegErr == nil && ffprobeErr == nil,
+		sessions:        auth.NewSessions(),
 	}
 }
 
@@ -50,13 +91,66 @@ func (s *Server) Setup() error {
 		log.Printf("WARN [server] ffmpeg/ffprobe not found in PATH - video playback will not work")
 	}
 
-	s.mux.HandleFunc("/api/list", s.HandleList)
-	s.mux.HandleFunc("/api/stream", s.HandleStreamStart)
-	s.mux.HandleFunc("/api/stop-stream", s.HandleStreamStop)
-	s.mux.HandleFunc("/api/upload", s.HandleUpload)
-	s.mux.HandleFunc("/content/", s.HandleContent)
+	users, err := auth.Open(s.config.DataPath)
+	if err != nil {
+		return fmt.Errorf("failed to open user store: %w", err)
+	}
+	s.users = users
+	tokens, err := auth.OpenTokens(s.config.DataPath)
+	if err != nil {
+		return fmt.Errorf("failed to open token store: %w", err)
+	}
+	s.tokens = tokens
+	acl, err := auth.OpenACL(s.config.DataPath)
+	if err != nil {
+		return fmt.Errorf("failed to open access control list: %w", err)
+	}
+	s.acl = acl
+	progressStore, err := progress.Open(s.config.DataPath)
+	if err != nil {
+		return fmt.Errorf("failed to open progress store: %w", err)
+	}
+	s.progress = progressStore
+	subtitleSync, err := subsync.Open(s.config.DataPath)
+	if err != nil {
+		return fmt.Errorf("failed to open subtitle offsets: %w", err)
+	}
+	s.subtitleSync = subtitleSync
+	idx, err := index.Open(s.config.DataPath)
+	if err != nil {
+		return fmt.Errorf("failed to open library index: %w", err)
+	}
+	s.index = idx
+	media.SetProbeStore(idx)
+	media.SetKeyframeStore(idx)
+	media.SetSubtitleCacheDir(filepath.Join(s.config.DataPath, "subtitles"))
+	media.SetSubtitleCharset(s.config.SubtitleCharset)
+	if !s.users.HasUsers() {
+		log.Printf("WARN [server] no users configured - authentication is disabled (add one with 'raikiri user add')")
+	}
+
+	s.mux.HandleFunc("/login", s.handleLoginPage)
+	s.mux.HandleFunc("/api/login", s.HandleLogin)
+	s.mux.HandleFunc("/api/logout", s.HandleLogout)
+	s.mux.HandleFunc("/api/me", s.HandleMe)
+	s.mux.HandleFunc("/api/tokens", s.HandleTokens)
+	s.mux.HandleFunc("/api/list", s.requireScope(s.HandleList, auth.ScopeBrowse))
+	s.mux.HandleFunc("/api/stream", s.requireScope(s.HandleStreamStart, auth.ScopeStream))
+	s.mux.HandleFunc("/api/stop-stream", s.requireScope(s.HandleStreamStop, auth.ScopeStream))
+	s.mux.HandleFunc("/api/stream-heartbeat", s.requireScope(s.HandleStreamHeartbeat, auth.ScopeStream))
+	s.mux.HandleFunc("/api/subtitle", s.requireScope(s.HandleSubtitle, auth.ScopeStream))
+	s.mux.HandleFunc("/api/subtitle/ass", s.requireScope(s.HandleSubtitleASS, auth.ScopeStream))
+	s.mux.HandleFunc("/api/subtitle/fonts", s.requireScope(s.HandleSubtitleFonts, auth.ScopeStream))
+	s.mux.HandleFunc("/api/subtitle/font", s.requireScope(s.HandleSubtitleFont, auth.ScopeStream))
+	s.mux.HandleFunc("/api/search", s.requireScope(s.HandleSearch, auth.ScopeBrowse))
+	s.mux.HandleFunc("/api/search/quotes", s.requireScope(s.HandleSearchQuotes, auth.ScopeBrowse))
+	s.mux.HandleFunc("/api/progress", s.requireScope(s.HandleProgress, auth.ScopeStream))
+	s.mux.HandleFunc("/api/feed/continue", s.requireScope(s.HandleFeedContinue, auth.ScopeBrowse, auth.ScopeStream))
+	s.mux.HandleFunc("/api/feed/recent", s.requireScope(s.HandleFeedRecent, auth.ScopeBrowse))
+	s.mux.HandleFunc("/api/upload", s.requireScope(s.HandleUpload, auth.ScopeUpload))
+	s.mux.HandleFunc("/content/", s.requireScope(s.HandleContent, auth.ScopeBrowse, auth.ScopeStream))
 
-	hlsHandler := s.makeHLSHandler()
+	hlsHandler := s.requireScope(s.makeHLSHandler().ServeHTTP, auth.ScopeStream)
 	s.mux.Handle("/hls/", http.StripPrefix("/hls/", hlsHandler))
 	s.mux.Handle("/api/hls/", http.StripPrefix("/api/hls/", hlsHandler))
 
@@ -89,24 +183,37 @@ func (s *Server) Run(ctx context.Context) error {
 		return fmt.Errorf("failed to create cache directory: %w", err)
 	}
 
-	go s.cleanupOldCacheSessions(ctx)
+	s.purgeStaleSessions()
+	go s.cleanCache(ctx)
+	go s.reapIdleStreams(ctx)
+	go s.index.Run(ctx, s.libraries(), s.config.RescanInterval, s.ffmpegAvailable)
+	defer s.index.Close()
+	defer func() {
+		if err := s.progress.Close(); err != nil {
+			log.Printf("ERROR [server] failed to save watch progress: %v", err)
+		}
+	}()
 
 	addr := fmt.Sprintf(":%d", s.config.Port)
-	srv := &http.Server{Addr: addr, Handler: s.mux}
+	srv := &http.Server{Addr: addr, Handler: s.withAuth(s.mux)}
 
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		<-ctx.Done()
 		log.Printf("INFO [server] shutting down server")
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 		srv.Shutdown(shutdownCtx)
+		s.shutdownStreams(10 * time.Second)
 	}()
 
-	log.Printf("INFO [server] raikiri running media=%s music=%s cache=%s port=%d", s.config.MediaPath, s.config.MusicPath, s.config.CachePath, s.config.Port)
+	log.Printf("INFO [server] raikiri running media=%s music=%s cache=%s data=%s port=%d", s.config.MediaPath, s.config.MusicPath, s.config.CachePath, s.config.DataPath, s.config.Port)
 
 	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
 		return err
 	}
+	<-done
 	return nil
 }
 
@@ -117,6 +224,43 @@ func (s *Server) getRoot(mode string) string {
 	return s.config.MediaPath
 }
 
+func (s *Server) libraries() []index.Library {
+	return []index.Library{
+		{Name: "media", Root: s.libraryRoot("media")},
+		{Name: "music", Root: s.libraryRoot("music")},
+	}
+}
+
+func (s *Server) libraryRoot(mode string) string {
+	root, _ := filepath.Abs(filepath.Clean(s.getRoot(mode)))
+	return root
+}
+
+// libraryEntries lists dir from the library index, or straight from disk
+// while the library has not finished its first scan.
+func (s *Server) libraryEntries(mode, dir string, recursive bool) ([]index.Entry, error) {
+	library := auth.NormalizeLibrary(mode)
+	root := s.libraryRoot(mode)
+	if s.index.Ready(library, root) {
+		return s.index.List(library, dir, recursive)
+	}
+	return index.ReadDisk(root, dir, recursive)
+}
+
+// libraryEntry looks up a single file or folder the same way.
+func (s *Server) libraryEntry(mode, rel string) (index.Entry, bool) {
+	library := auth.NormalizeLibrary(mode)
+	root := s.libraryRoot(mode)
+	if s.index.Ready(library, root) {
+		return s.index.Get(library, rel)
+	}
+	info, err := os.Stat(filepath.Join(root, filepath.FromSlash(rel)))
+	if err != nil {
+		return index.Entry{}, false
+	}
+	return index.NewEntry(rel, info), true
+}
+
 func (s *Server) resolveWithinRoot(mode, rel string) (string, bool) {
 	cleanRoot, _ := filepath.Abs(filepath.Clean(s.getRoot(mode)))
 	full, _ := filepath.Abs(filepath.Join(cleanRoot, rel))
@@ -128,54 +272,98 @@ func (s *Server) resolveWithinRoot(mode, rel string) (string, bool) {
 	return full, ok
 }
 
-func (s *Server) cleanupOldCacheSessions(ctx context.Context) {
-	for {
-		now := time.Now()
-		next3AM := time.Date(now.Year(), now.Month(), now.Day(), 3, 0, 0, 0, now.Location())
-		if now.After(next3AM) {
-			next3AM = next3AM.Add(24 * time.Hour)
+// purgeStaleSessions removes session directories left behind by a server
+// that did not shut down cleanly, along with directories it was partway
+// through evicting. No session outlives the process, so at startup every
+// one of them is stale; shared streams are kept for replays.
+func (s *Server) purgeStaleSessions() {
+	entries, err := os.ReadDir(s.config.CachePath)
+	if err != nil {
+		log.Printf("ERROR [server] error reading cache directory: %v", err)
+		return
+	}
+	removed := 0
+	for _, entry := range entries {
+		if !entry.IsDir() || !(cache.IsSessionDir(entry.Name()) || cache.IsRetired(entry.Name())) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(s.config.CachePath, entry.Name())); err != nil {
+			log.Printf("ERROR [server] error removing directory dir=%s: %v", entry.Name(), err)
+			continue
 		}
+		removed++
+	}
+	if removed > 0 {
+		log.Printf("INFO [server] removed stale session directories count=%d", removed)
+	}
+}
 
-		duration := next3AM.Sub(now)
-		log.Printf("INFO [server] cache cleanup scheduled at=%s in=%s", next3AM.Format("2006-01-02 15:04:05"), duration.Round(time.Second).String())
+// Converted subtitles are kept in the data directory until unused for this
+// long.
+const subtitleMaxAge = 30 * 24 * time.Hour
 
+// cleanCache applies the cache policy every CacheInterval, and prunes
+// unused subtitles.
+func (s *Server) cleanCache(ctx context.Context) {
+	if s.config.CacheInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.config.CacheInterval)
+	defer ticker.Stop()
+	for {
+		s.evictCache()
+		if n := media.PruneSubtitleCache(subtitleMaxAge); n > 0 {
+			log.Printf("INFO [server] pruned unused subtitles count=%d", n)
+		}
 		select {
 		case <-ctx.Done():
 			return
-		case <-time.After(duration):
+		case <-ticker.C:
 		}
+	}
+}
 
-		log.Printf("INFO [server] starting cache cleanup")
-		cutoffTime := time.Now().Add(-3 * 24 * time.Hour)
-
-		entries, err := os.ReadDir(s.config.CachePath)
-		if err != nil {
-			log.Printf("ERROR [server] error reading cache directory: %v", err)
+// evictCache removes the stream directories the cache policy selects,
+// never one a stream in activeStreams is using.
+func (s *Server) evictCache() {
+	entries, err := cache.Scan(s.config.CachePath)
+	if err != nil {
+		log.Printf("ERROR [server] error reading cache directory: %v", err)
+		return
+	}
+	active := func(name string) bool {
+		s.streamMutex.Lock()
+		defer s.streamMutex.Unlock()
+		_, ok := s.activeStreams[name]
+		return ok
+	}
+	var removed int
+	var freed int64
+	for _, e := range s.config.CachePolicy.Select(entries, time.Now(), active) {
+		// Moving the directory away under the lock keeps a stream from
+		// opening it while it is being deleted.
+		s.streamMutex.Lock()
+		var dir string
+		_, inUse := s.activeStreams[e.Name]
+		if !inUse {
+			dir, err = cache.Retire(s.config.CachePath, e.Name)
+		}
+		s.streamMutex.Unlock()
+		if inUse {
 			continue
 		}
-
-		removedCount := 0
-		for _, entry := range entries {
-			if !entry.IsDir() {
-				continue
-			}
-			dirPath := filepath.Join(s.config.CachePath, entry.Name())
-			var dirTime time.Time
-			if after, ok := strings.CutPrefix(entry.Name(), "s_"); ok {
-				if unixNano, err := strconv.ParseInt(after, 10, 64); err == nil {
-					dirTime = time.Unix(0, unixNano)
-				}
-			}
-
-			if dirTime.Before(cutoffTime) {
-				log.Printf("INFO [server] removing old cache directory dir=%s created=%s", entry.Name(), dirTime.Format("2006-01-02 15:04:05"))
-				if err := os.RemoveAll(dirPath); err != nil {
-					log.Printf("ERROR [server] error removing directory dir=%s: %v", entry.Name(), err)
-				} else {
-					removedCount++
-				}
-			}
+		if err == nil {
+			err = os.RemoveAll(dir)
 		}
-		log.Printf("INFO [server] cache cleanup complete removed=%d", removedCount)
+		if err != nil {
+			log.Printf("ERROR [server] error removing directory dir=%s: %v", e.Name, err)
+			continue
+		}
+		log.Printf("DEBUG [server] evicted cache directory dir=%s size=%d last_used=%s", e.Name, e.Size, e.LastUsed.Format("2006-01-02 15:04:05"))
+		removed++
+		freed += e.Size
+	}
+	if removed > 0 {
+		log.Printf("INFO [server] cache cleanup complete removed=%d freed=%s", removed, media.FormatFileSize(freed))
 	}
 }
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tanq16/raikiri/internal/media"
+)
+
+// streamSession is the output of one way of playing a video: a single HLS
+// stream, or one stream per rendition behind a master playlist. VOD streams
+// are keyed by what goes into their segments, so viewers of the same file
+// with the same settings share one, and its directory is kept after the
+// last of them leaves for later plays to reuse.
+type streamSession struct {
+	id  string
+	dir string
+	// key is empty for streams only their one viewer can use: event
+	// playlists and the subtitles of direct play.
+	key string
+	// variants are keyed by subdirectory; a single stream lives in the
+	// session directory itself under "".
+	variants map[string]*hlsSession
+	// master is the base name of the master playlists, empty when the
+	// stream has none.
+	master string
+	// audio lists the audio renditions of the master playlist, default
+	// first; it is empty when audio is muxed into the video.
+	audio     []audioInfo
+	subtitles []subtitleInfo
+	// subtitleTracks are where the subtitles come from, in the same order;
+	// they are read from input and shifted by subtitleOffset.
+	input          string
+	subtitleTracks []media.SubtitleTrack
+	subtitleOffset float64
+
+	// refs counts the viewers attached; it is guarded by Server.streamMutex.
+	refs int
+	// slot is the transcode capacity the stream holds from when its first
+	// viewer sets it up until its last leaves; nil when it needs none.
+	slot *streamSlot
+	// ready is closed once the directory is set up, with err telling how
+	// that went.
+	ready chan struct{}
+	err   error
+}
+
+// viewer is one client playing a stream. Its id is the session id clients
+// see, so its segment fetches and heartbeats keep it alive. Ids are random
+// and only user, who opened the stream, may use one.
+type viewer struct {
+	id     string
+	user   string
+	stream *streamSession
+	// limited is set when the viewer counts against its user's stream
+	// limit.
+	limited bool
+
+	mu       sync.Mutex
+	lastSeen time.Time
+}
+
+// heartbeat records that the client is still playing.
+func (v *viewer) heartbeat() {
+	v.mu.Lock()
+	v.lastSeen = time.Now()
+	v.mu.Unlock()
+}
+
+// idle is how long ago the client last showed interest in the stream.
+func (v *viewer) idle() time.Duration {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return time.Since(v.lastSeen)
+}
+
+// transcodeKey identifies the segments a VOD stream produces: the file
+// version, the source mode, the audio track and the ffmpeg arguments and
+// segment plan of every rendition.
+func transcodeKey(filePath, source string, audio int, variants map[string]*hlsSession) (string, error) {
+	filePath, err := filepath.Abs(filePath)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\x00%d\x00%d\x00%s\x00%d\x00", filePath, info.Size(), info.ModTime().UnixNano(), source, audio)
+	names := make([]string, 0, len(variants))
+	for name := range variants {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		h := variants[name]
+		fmt.Fprintf(hash, "%s\x00%s\x00%t\x00%t\x00%v\x00", name, strings.Join(h.codecArgs, " "), h.grid, h.ts, h.starts)
+	}
+	return "t_" + hex.EncodeToString(hash.Sum(nil))[:24], nil
+}
+
+// lookup maps a path inside the session directory to the stream serving it
+// and the file name within that stream.
+func (st *streamSession) lookup(rel string) (*hlsSession, string) {
+	if h, ok := st.variants[""]; ok {
+		return h, rel
+	}
+	name, file, ok := strings.Cut(rel, "/")
+	if !ok {
+		return nil, rel
+	}
+	return st.variants[name], file
+}
+
+// touch records that viewerID fetched from h and releases its jobs on
+// renditions it has moved away from.
+func (st *streamSession) touch(viewerID string, h *hlsSession) {
+	h.mu.Lock()
+	if job := h.jobOfLocked(viewerID); job != nil {
+		job.viewers[viewerID] = time.Now()
+	}
+	h.mu.Unlock()
+	for _, other := range st.variants {
+		if other == h {
+			continue
+		}
+		other.mu.Lock()
+		if job := other.jobOfLocked(viewerID); job != nil && time.Since(job.viewers[viewerID]) > variantIdle {
+			log.Printf("INFO [server] leaving unused rendition session=%s viewer=%s", other.id, viewerID)
+			other.releaseLocked(viewerID)
+		}
+		other.mu.Unlock()
+	}
+}
+
+// stopViewer releases the jobs viewerID uses.
+func (st *streamSession) stopViewer(viewerID string) {
+	for _, h := range st.variants {
+		h.stopViewer(viewerID)
+	}
+}
+
+func (st *streamSession) stop() {
+	for _, h := range st.variants {
+		h.stop()
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tanq16/raikiri/internal/cache"
+)
+
+func TestTranscodeKey(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "movie.mkv")
+	if err := os.WriteFile(file, []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	variants := func(args ...string) map[string]*hlsSession {
+		return map[string]*hlsSession{
+			"1080p": {codecArgs: args, starts: []float64{0, 6}},
+			"720p":  {codecArgs: []string{"-c:v", "libx264"}, starts: []float64{0, 6}, grid: true},
+		}
+	}
+	base, err := transcodeKey(file, "hls-abr", 1, variants("-c:v", "copy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cache.IsStreamDir(base) {
+		t.Fatalf("transcodeKey() = %q, not a cache stream directory", base)
+	}
+	for range 20 {
+		// Map order must not matter.
+		if key, _ := transcodeKey(file, "hls-abr", 1, variants("-c:v", "copy")); key != base {
+			t.Fatalf("transcodeKey() = %q then %q for the same stream", base, key)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		source   string
+		audio    int
+		variants map[string]*hlsSession
+	}{
+		{"source", "hls-fmp4", 1, variants("-c:v", "copy")},
+		{"audio", "hls-abr", 2, variants("-c:v", "copy")},
+		{"codec args", "hls-abr", 1, variants("-c:v", "libx264")},
+		{"renditions", "hls-abr", 1, map[string]*hlsSession{"1080p": {codecArgs: []string{"-c:v", "copy"}, starts: []float64{0, 6}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := transcodeKey(file, tt.source, tt.audio, tt.variants)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key == base {
+				t.Errorf("transcodeKey() = %q, same as for a different stream", key)
+			}
+		})
+	}
+
+	t.Run("file changed", func(t *testing.T) {
+		if err := os.WriteFile(file, []byte("another video"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if key, _ := transcodeKey(file, "hls-abr", 1, variants("-c:v", "copy")); key == base {
+			t.Errorf("transcodeKey() = %q, unchanged after the file changed", key)
+		}
+	})
+}
+
+func TestSharedStreamHoldsOneSlot(t *testing.T) {
+	s := New(Config{MaxTranscodes: 1, MaxUserStreams: 1})
+	setups := 0
+	open := func(user string) (*viewer, error) {
+		stream := &streamSession{id: "t_0123456789abcdef01234567", key: "t_0123456789abcdef01234567", dir: t.TempDir()}
+		return s.openStream(context.Background(), user, stream, transcodeWeight, func() error {
+			setups++
+			return nil
+		})
+	}
+
+	first, err := open("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The server is at capacity, yet joining needs none of it.
+	second, err := open("bob")
+	if err != nil {
+		t.Fatalf("joining a shared stream: %v", err)
+	}
+	if second.stream != first.stream || setups != 1 {
+		t.Fatalf("second viewer got its own stream (setups=%d)", setups)
+	}
+	if s.limiter.used != transcodeWeight {
+		t.Errorf("limiter used = %d with two viewers of one stream, want %d", s.limiter.used, transcodeWeight)
+	}
+	// Each viewer still counts against its user.
+	if _, err := open("alice"); !errors.Is(err, errUserLimit) {
+		t.Errorf("second viewer of alice: err = %v, want %v", err, errUserLimit)
+	}
+
+	s.stopSession(first.id)
+	if s.limiter.used != transcodeWeight {
+		t.Errorf("limiter used = %d after the first viewer left, want %d", s.limiter.used, transcodeWeight)
+	}
+	s.stopSession(second.id)
+	if s.limiter.used != 0 || len(s.limiter.users) != 0 {
+		t.Errorf("limiter used = %d users = %v after the last viewer left, want none", s.limiter.used, s.limiter.users)
+	}
+}
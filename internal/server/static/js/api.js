@@ -1,8 +1,18 @@
 const API = {
+    // fetch wrapper that sends the browser to the login page once the session expires.
+    async request(url, options) {
+        const res = await fetch(url, options);
+        if (res.status === 401) {
+            window.location.href = '/login';
+            throw new Error('Unauthorized');
+        }
+        return res;
+    },
+
     async list(path, mode, recursive = false) {
         try {
             const params = new URLSearchParams({ path, mode, recursive });
-            const res = await fetch(`/api/list?${params.toString()}`);
+            const res = await this.request(`/api/list?${params.toString()}`);
             if (!res.ok) throw new Error('Failed to fetch');
             return await res.json();
         } catch (e) {
@@ -11,6 +21,34 @@ const API = {
         }
     },
 
+    // Ranked search beneath path; returns the first page of results.
+    async search(query, mode, path = '', limit = 200) {
+        try {
+            const params = new URLSearchParams({ q: query, mode, path, limit });
+            const res = await this.request(`/api/search?${params.toString()}`);
+            if (!res.ok) throw new Error('Failed to search');
+            const data = await res.json();
+            return data.results;
+        } catch (e) {
+            console.error(e);
+            return [];
+        }
+    },
+
+    // Videos beneath path with a subtitle line containing every word of query.
+    async searchQuotes(query, mode, path = '', limit = 50) {
+        try {
+            const params = new URLSearchParams({ q: query, mode, path, limit });
+            const res = await this.request(`/api/search/quotes?${params.toString()}`);
+            if (!res.ok) throw new Error('Failed to search dialogue');
+            const data = await res.json();
+            return data.results;
+        } catch (e) {
+            console.error(e);
+            return [];
+        }
+    },
+
     async upload(files, path, mode) {
         try {
             const formData = new FormData();
@@ -20,7 +58,7 @@ const API = {
             formData.append('path', path);
             formData.append('mode', mode);
 
-            const res = await fetch('/api/upload', {
+            const res = await this.request('/api/upload', {
                 method: 'POST',
                 body: formData
             });
@@ -31,6 +69,80 @@ const API = {
         }
     },
 
+    // kind is "continue" or "recent".
+    async feed(kind, mode, limit) {
+        try {
+            const params = new URLSearchParams({ mode });
+            if (limit) params.set('limit', limit);
+            const res = await this.request(`/api/feed/${kind}?${params.toString()}`);
+            if (!res.ok) throw new Error('Failed to fetch');
+            return await res.json();
+        } catch (e) {
+            console.error(e);
+            return [];
+        }
+    },
+
+    async getProgress(path, mode) {
+        try {
+            const params = new URLSearchParams({ path, mode });
+            const res = await this.request(`/api/progress?${params.toString()}`);
+            if (!res.ok) return null;
+            return await res.json();
+        } catch (e) {
+            console.error(e);
+            return null;
+        }
+    },
+
+    saveProgress(entry, useBeacon = false) {
+        const body = JSON.stringify(entry);
+        if (useBeacon && navigator.sendBeacon) {
+            navigator.sendBeacon('/api/progress', new Blob([body], { type: 'application/json' }));
+            return;
+        }
+        fetch('/api/progress', {
+            method: 'PUT',
+            headers: { 'Content-Type': 'application/json' },
+            body
+        }).catch(e => console.error('Saving progress failed', e));
+    },
+
+    // What this browser plays natively, posted with every stream request so
+    // the server only remuxes or transcodes when it has to.
+    deviceProfile() {
+        if (this._profile) return this._profile;
+        const video = document.createElement('video');
+        const can = (type) => (window.MediaSource && MediaSource.isTypeSupported(type)) || video.canPlayType(type) === 'probably';
+        const pick = (checks) => Object.keys(checks).filter(name => checks[name].some(can));
+
+        const containers = ['mp4', 'mov'];
+        if (can('video/webm; codecs="vp9"') || can('video/webm; codecs="vp8"')) containers.push('webm');
+        const hdrDisplay = window.matchMedia && window.matchMedia('(dynamic-range: high)').matches;
+        this._profile = {
+            name: 'web',
+            containers,
+            videoCodecs: pick({
+                h264: ['video/mp4; codecs="avc1.640028"'],
+                hevc: ['video/mp4; codecs="hvc1.1.6.L120.90"', 'video/mp4; codecs="hev1.1.6.L120.90"'],
+                vp9: ['video/webm; codecs="vp9"', 'video/mp4; codecs="vp09.00.10.08"'],
+                av1: ['video/mp4; codecs="av01.0.05M.08"', 'video/webm; codecs="av01.0.05M.08"'],
+            }),
+            audioCodecs: pick({
+                aac: ['audio/mp4; codecs="mp4a.40.2"'],
+                mp3: ['audio/mpeg', 'audio/mp4; codecs="mp3"'],
+                opus: ['audio/webm; codecs="opus"', 'audio/mp4; codecs="opus"'],
+                flac: ['audio/flac', 'audio/mp4; codecs="flac"'],
+                ac3: ['audio/mp4; codecs="ac-3"'],
+                eac3: ['audio/mp4; codecs="ec-3"'],
+            }),
+            maxAudioChannels: 2,
+            audioSampleRates: [48000],
+            hdr: Boolean(hdrDisplay && can('video/mp4; codecs="hvc1.2.4.L153.B0"')),
+        };
+        return this._profile;
+    },
+
     getContentUrl(path, mode) {
         const cleanPath = path.startsWith('/') ? path.substring(1) : path;
         const encoded = cleanPath.split('/').map(s => encodeURIComponent(s)).join('/');
@@ -4,7 +4,6 @@ import API from './api.js';
 import Player from './player.js';
 
 const App = {
-    _recursiveCache: null,
     _searchResults: null,
     _searchDebounce: null,
 
@@ -71,8 +70,7 @@ const App = {
         UI.renderBreadcrumbs();
         UI.render(items);
 
-        // Reset search state so cache/results don't bleed across directories
-        this._recursiveCache = null;
+        // Reset search state so results don't bleed across directories
         this._searchResults = null;
         clearTimeout(this._searchDebounce);
         document.getElementById('search-input').value = '';
@@ -91,27 +89,31 @@ const App = {
     },
 
     async _runSearch(query) {
-        if (this._recursiveCache === null) {
-            const path = state.path;
-            const mode = state.mode;
-            let pool = await API.list(path, mode, true);
-            // Don't poison the cache if the user navigated or switched mode during the fetch
-            if (state.path !== path || state.mode !== mode) return;
-            if (mode === 'music') {
-                pool = pool.filter(i => i.type === 'folder' || i.type === 'audio');
-            }
-            this._recursiveCache = pool;
-        }
-
-        // Stale guard: bail if the search box changed during the in-flight fetch
+        const path = state.path;
+        const mode = state.mode;
+        const results = await this._search(query, mode, path);
+        // Stale guard: bail if the user navigated or the search box changed during the fetch
+        if (state.path !== path || state.mode !== mode) return;
         if (document.getElementById('search-input').value.trim() !== query) return;
 
-        const lower = query.toLowerCase();
-        const filtered = this._recursiveCache.filter(item => item.name.toLowerCase().includes(lower));
-        this._searchResults = filtered;
-        UI.render(filtered, { showPath: true });
+        this._searchResults = results;
+        UI.render(results, { showPath: true });
     },
     
+    // A query starting with a double quote searches subtitle dialogue; each
+    // video found plays from its best matching line.
+    async _search(query, mode, path) {
+        if (!query.startsWith('"')) return API.search(query, mode, path);
+        const quote = query.replace(/"/g, '').trim();
+        if (!quote) return [];
+        const results = await API.searchQuotes(quote, mode, path);
+        return results.map(({ matches, ...item }) => ({
+            ...item,
+            startAt: matches[0].start,
+            quote: matches[0].text,
+        }));
+    },
+
     switchTab(mode) {
         state.setMode(mode);
     },
@@ -41,6 +41,16 @@ const Elements = {
         return slash > -1 ? item.path.slice(0, slash) : '';
     },
 
+    // The matched line of a dialogue search result, with where it is said.
+    formatQuote(item) {
+        const t = Math.floor(item.startAt || 0);
+        const h = Math.floor(t / 3600);
+        const m = Math.floor(t / 60) % 60;
+        const sec = String(t % 60).padStart(2, '0');
+        const time = h > 0 ? `${h}:${String(m).padStart(2, '0')}:${sec}` : `${m}:${sec}`;
+        return `${time} “${item.quote}”`;
+    },
+
     createGridItem(item, showPath = false) {
         const isMedia = ['image', 'video', 'audio'].includes(item.type);
         const iconName = this.getIconName(item.type);
@@ -80,9 +90,11 @@ const Elements = {
         }
 
         const parentDir = showPath ? this.getParentDir(item) : '';
-        const secondary = parentDir
-            ? Escape.html(parentDir)
-            : Escape.html(item.size || '');
+        const secondary = item.quote
+            ? Escape.html(this.formatQuote(item))
+            : parentDir
+                ? Escape.html(parentDir)
+                : Escape.html(item.size || '');
 
         return `
             <div class="flex flex-col gap-2 group w-full select-none"
@@ -110,9 +122,11 @@ const Elements = {
 
         // When showing search results, use the parent directory as the secondary
         // line so identically-named files in different folders are distinguishable.
-        const mobileSecondary = parentDir
-            ? `<span class="truncate">${Escape.html(parentDir)}</span>`
-            : `<span>${Escape.html(typeLabel)}</span>${item.type !== 'folder' ? `<span class="text-overlay0">•</span><span>${Escape.html(size)}</span>` : ''}`;
+        const mobileSecondary = item.quote
+            ? `<span class="truncate">${Escape.html(this.formatQuote(item))}</span>`
+            : parentDir
+                ? `<span class="truncate">${Escape.html(parentDir)}</span>`
+                : `<span>${Escape.html(typeLabel)}</span>${item.type !== 'folder' ? `<span class="text-overlay0">•</span><span>${Escape.html(size)}</span>` : ''}`;
 
         return `
             <div class="group w-full"
@@ -127,8 +141,8 @@ const Elements = {
                             ${mobileSecondary}
                         </div>
                     </div>
-                    ${parentDir
-                        ? `<span class="hidden md:block text-xs text-subtext0 truncate md:col-span-3">${Escape.html(parentDir)}</span>`
+                    ${item.quote || parentDir
+                        ? `<span class="hidden md:block text-xs text-subtext0 truncate md:col-span-3">${Escape.html(item.quote ? this.formatQuote(item) : parentDir)}</span>`
                         : `<span class="hidden md:block text-xs text-subtext0 font-semibold uppercase tracking-wide">${Escape.html(typeLabel)}</span>
                     <span class="hidden md:block text-xs text-subtext0">${Escape.html(size)}</span>
                     <span class="hidden md:block text-xs text-subtext0">${Escape.html(modified)}</span>`}
@@ -155,6 +169,31 @@ const Elements = {
         `;
     },
     
+    createFeedHeader(title) {
+        return `<div class="px-2 pt-2 pb-1 text-xs font-bold uppercase tracking-wide text-subtext0">${Escape.html(title)}</div>`;
+    },
+
+    createFeedItem(item, section, idx) {
+        let detail = item.modified || '';
+        if (item.upNext) {
+            detail = 'Up next';
+        } else if (item.duration) {
+            const left = Math.max(0, Math.round((item.duration - item.position) / 60));
+            detail = `${left}m left`;
+        }
+        const percent = item.duration ? Math.min(100, (item.position / item.duration) * 100) : 0;
+        return `
+            <div class="p-2 rounded hover:bg-surface0/50 cursor-pointer text-subtext1" data-feed-section="${Escape.attr(section)}" data-feed-index="${Escape.attr(idx)}">
+                <div class="flex items-center gap-3">
+                    <i data-lucide="${this.getIconName(item.type)}" size="14"></i>
+                    <div class="flex-1 truncate text-sm" title="${Escape.attr(item.path)}">${Escape.html(item.name)}</div>
+                    <span class="text-xs text-subtext0 shrink-0">${Escape.html(detail)}</span>
+                </div>
+                ${percent > 0 ? `<div class="mt-1 ml-7 h-0.5 bg-surface0 rounded-full overflow-hidden"><div class="h-full bg-mauve" style="width: ${percent.toFixed(1)}%"></div></div>` : ''}
+            </div>
+        `;
+    },
+
     createHistoryItem(path, idx) {
         return `
             <div class="flex items-center gap-3 p-2 rounded hover:bg-surface0/50 text-subtext1">
@@ -16,10 +16,19 @@ const Player = {
     activeSubtitleIndex: null,
     availableAudioTracks: [],
     selectedAudioIndex: null,
+    // Audio tracks the HLS stream carries as renditions, in hls.js order.
+    _audioRenditions: [],
+    // Bitmap subtitle burned into the video by the server, if any.
+    _burnSubtitle: null,
+    // libass renderer showing an ASS subtitle with its original styling.
+    _assRenderer: null,
     _advancing: false,
     _directMode: false,
     _currentSource: null,
     _availableSources: [],
+    _timeOffset: 0,
+    _progressItem: null,
+    _lastProgressSave: 0,
 
     init() {
         this.audioEl = document.getElementById('ep-audio');
@@ -34,10 +43,12 @@ const Player = {
         this.videoEl.addEventListener('ended', () => this.next());
         this.videoEl.addEventListener('timeupdate', () => {
             const duration = this.videoDuration || this.videoEl.duration;
-            UI.updateProgress(this.videoEl.currentTime, duration);
+            const currentTime = this._videoTime();
+            UI.updateProgress(currentTime, duration);
             this.updateMediaSessionPosition();
-            if (this.videoDuration && this.videoEl.currentTime > 0 && !this._advancing) {
-                const remaining = this.videoDuration - this.videoEl.currentTime;
+            if (Date.now() - this._lastProgressSave > 15000) this._saveProgress();
+            if (this.videoDuration && currentTime > 0 && !this._advancing) {
+                const remaining = this.videoDuration - currentTime;
                 if (remaining < 1 && remaining >= 0) {
                     this.next();
                 }
@@ -46,6 +57,7 @@ const Player = {
 
         this.audioEl.addEventListener('loadedmetadata', () => this.updateMediaSessionPosition());
         this.videoEl.addEventListener('loadedmetadata', () => this.updateMediaSessionPosition());
+        this.videoEl.addEventListener('pause', () => this._saveProgress());
 
         if ('mediaSession' in navigator) {
             navigator.mediaSession.setActionHandler('play', () => this.play());
@@ -55,10 +67,19 @@ const Player = {
         }
 
         window.addEventListener('beforeunload', () => {
+            this._saveProgress(true);
             if (this.currentSessionId) {
                 navigator.sendBeacon(`/api/stop-stream?session=${this.currentSessionId}`);
             }
         });
+
+        // Keeps the stream alive while paused; the server reaps sessions
+        // that go quiet, such as those of a closed tab.
+        setInterval(() => {
+            if (this.currentSessionId) {
+                fetch(`/api/stream-heartbeat?session=${this.currentSessionId}`, { method: 'POST' });
+            }
+        }, 30000);
     },
 
     // ── Queue Management ────────────────────────────────────────────────
@@ -112,7 +133,34 @@ const Player = {
 
     // ── Video Helpers ───────────────────────────────────────────────────
 
+    // HLS streams started mid-file report positions relative to startOffset.
+    _videoTime() {
+        return this.videoEl.currentTime + this._timeOffset;
+    },
+
+    _seekVideo(time) {
+        if (this._directMode || time >= this._timeOffset) {
+            this.videoEl.currentTime = time - this._timeOffset;
+        } else {
+            this._reloadStream(this._currentSource, this.selectedAudioIndex, time);
+        }
+    },
+
+    _saveProgress(useBeacon = false) {
+        if (!this._progressItem || !this.videoDuration) return;
+        const position = this._videoTime();
+        if (!(position > 0)) return;
+        this._lastProgressSave = Date.now();
+        API.saveProgress({
+            ...this._progressItem,
+            position,
+            duration: this.videoDuration,
+        }, useBeacon);
+    },
+
     _cleanupVideo() {
+        this._saveProgress();
+        this._destroyASS();
         if (this.hls) {
             this.hls.destroy();
             this.hls = null;
@@ -130,19 +178,25 @@ const Player = {
             this.currentSessionId = null;
         }
         this.videoDuration = null;
+        this._timeOffset = 0;
     },
 
-    _loadVideoHLS(url) {
+    // start is an absolute position; VOD playlists begin loading right there
+    // instead of at the first segment.
+    _loadVideoHLS(url, start = 0) {
         if (Hls.isSupported()) {
             this.hls = new Hls({
                 enableWorker: true,
                 lowLatencyMode: false,
-                startPosition: 0,
+                startPosition: Math.max(start - this._timeOffset, 0),
                 stretchShortVideoTrack: true,
                 backBufferLength: 60,
                 maxMaxBufferLength: 120,
                 nudgeMaxRetry: 5,
                 manifestLoadingMaxRetry: 2,
+                // Subtitle renditions are for native players; this one adds
+                // the same tracks as sidecar files.
+                enableWebVTT: false,
             });
             this.hls.loadSource(url);
             this.hls.attachMedia(this.videoEl);
@@ -189,10 +243,13 @@ const Player = {
         this.audioEl.removeAttribute('src');
         this.audioEl.load();
 
+        this._progressItem = null;
         this.availableSubtitles = [];
         this.activeSubtitleIndex = null;
+        this._burnSubtitle = null;
         this.availableAudioTracks = [];
         this.selectedAudioIndex = null;
+        this._audioRenditions = [];
         this._currentSource = null;
         this._availableSources = [];
         UI.updateSubtitleButton(false);
@@ -222,18 +279,32 @@ const Player = {
             } catch (e) {}
 
             try {
-                const data = await this._requestSource(item);
+                // Dialogue search results start at the line found, once.
+                let start;
+                if (item.startAt != null) {
+                    start = item.startAt;
+                    delete item.startAt;
+                } else {
+                    const saved = await API.getProgress(item.path, state.mode);
+                    start = saved && !saved.completed ? (saved.position || 0) : 0;
+                }
+                const data = await this._requestSource(item, null, null, start);
+                this._progressItem = { path: item.path, mode: state.mode };
+                this._timeOffset = data.startOffset || 0;
                 this.currentSessionId = data.sessionId;
                 this.videoDuration = data.duration || null;
                 this.availableSubtitles = data.subtitles || [];
                 this.availableAudioTracks = data.audioTracks || [];
                 this.selectedAudioIndex = (typeof data.selectedAudio === 'number') ? data.selectedAudio : null;
+                this._audioRenditions = data.audioRenditions || [];
                 this._availableSources = data.availableSources || [];
                 this._currentSource = data.source;
                 this.videoEl.classList.remove('hidden');
 
                 while (this.videoEl.firstChild) this.videoEl.removeChild(this.videoEl.firstChild);
 
+                this._seekOnLoad(data.start || 0);
+
                 if (data.mode === 'direct') {
                     this._directMode = true;
                     this.videoEl.src = data.url;
@@ -243,16 +314,21 @@ const Player = {
                         this._directMode = false;
                         this.videoEl.removeAttribute('src');
                         this.videoEl.load();
-                        await this._autoFallback(item);
+                        await this._autoFallback(item, data.start || 0);
                     }
                 } else {
                     this._directMode = false;
-                    this._loadVideoHLS(data.url);
+                    this._loadVideoHLS(data.url, data.start || 0);
                 }
 
                 UI.updateSubtitleButton(this.availableSubtitles.length > 0);
                 UI.updateAudioButton(this.availableAudioTracks.length > 1);
                 UI.updateSourceButton(this._currentSource, true);
+                // Forced subtitles show by default, then ones flagged default.
+                // Bitmap tracks would need a transcode, so only text ones qualify.
+                const text = this.availableSubtitles.filter(s => !s.burnIn);
+                const preferred = text.find(s => s.forced) || text.find(s => s.default);
+                if (preferred) this.setSubtitle(preferred.index);
                 this.isPlaying = true;
                 loaded = true;
             } catch (e) {
@@ -328,7 +404,7 @@ const Player = {
         } else if (item.type === 'video') {
             const duration = this.videoDuration || this.videoEl.duration;
             if (duration) {
-                this.videoEl.currentTime = (percent / 100) * duration;
+                this._seekVideo((percent / 100) * duration);
                 this.updateMediaSessionPosition();
             }
         }
@@ -337,6 +413,12 @@ const Player = {
     seekBy(seconds) {
         if (!this.queue.length) return;
         const item = this.queue[this.currentIndex];
+        if (item.type === 'video' && this.videoDuration) {
+            const target = Math.min(Math.max(0, this._videoTime() + seconds), Math.max(this.videoDuration - 0.01, 0));
+            this._seekVideo(target);
+            this.updateMediaSessionPosition();
+            return;
+        }
         let media = null;
         if (item.type === 'audio') media = this.audioEl;
         else if (item.type === 'video') media = this.videoEl;
@@ -374,6 +456,7 @@ const Player = {
         this.audioEl.pause();
         try { this.audioEl.removeAttribute('src'); this.audioEl.load(); } catch (e) {}
         this._cleanupVideo();
+        this._progressItem = null;
         clearTimeout(this.imageTimer);
         this.queue = [];
         this.currentIndex = -1;
@@ -417,7 +500,7 @@ const Player = {
             }
             playbackRate = this.isPlaying ? (this.audioEl.playbackRate || 1.0) : 0;
         } else if (item.type === 'video') {
-            currentTime = this.videoEl.currentTime;
+            currentTime = this._videoTime();
             duration = this.videoDuration || this.videoEl.duration;
             playbackRate = this.isPlaying ? (this.videoEl.playbackRate || 1.0) : 0;
         }
@@ -441,16 +524,30 @@ const Player = {
 
     // ── Video Source Management ──────────────────────────────────────────
 
-    async _requestSource(item, source, audioIndex) {
+    async _requestSource(item, source, audioIndex, start) {
         const params = new URLSearchParams({ file: item.path, mode: state.mode });
         if (source) params.set('source', source);
         if (audioIndex != null) params.set('audio', audioIndex);
-        const res = await fetch(`/api/stream?${params}`);
+        if (this._burnSubtitle != null) params.set('burn', this._burnSubtitle);
+        if (start > 0) params.set('start', start.toFixed(3));
+        const res = await API.request(`/api/stream?${params}`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(API.deviceProfile()),
+        });
         if (!res.ok) throw new Error(await res.text());
         return res.json();
     },
 
-    async _autoFallback(item) {
+    // Seeks to an absolute position once the freshly loaded source has data.
+    _seekOnLoad(time) {
+        const target = time - this._timeOffset;
+        if (!(target > 0)) return;
+        const seekOnce = () => { this.videoEl.currentTime = target; this.videoEl.removeEventListener('loadeddata', seekOnce); };
+        this.videoEl.addEventListener('loadeddata', seekOnce);
+    },
+
+    async _autoFallback(item, start = 0) {
         const currentIdx = this._availableSources.indexOf(this._currentSource);
         if (currentIdx < 0 || this._availableSources.length <= 1) return;
         const nextIdx = (currentIdx + 1) % this._availableSources.length;
@@ -458,19 +555,22 @@ const Player = {
         if (nextSource === this._currentSource) return;
         try {
             this._cleanupVideo();
-            const data = await this._requestSource(item, nextSource);
+            const data = await this._requestSource(item, nextSource, null, start);
+            this._timeOffset = data.startOffset || 0;
             this.currentSessionId = data.sessionId;
             this.videoDuration = data.duration || null;
             this._currentSource = data.source;
+            this._audioRenditions = data.audioRenditions || [];
             this.videoEl.classList.remove('hidden');
             while (this.videoEl.firstChild) this.videoEl.removeChild(this.videoEl.firstChild);
+            this._seekOnLoad(start);
             if (data.mode === 'direct') {
                 this._directMode = true;
                 this.videoEl.src = data.url;
                 await this.videoEl.play();
             } else {
                 this._directMode = false;
-                this._loadVideoHLS(data.url);
+                this._loadVideoHLS(data.url, start);
             }
             UI.updateSourceButton(this._currentSource, true);
         } catch (e) {
@@ -485,65 +585,49 @@ const Player = {
         const currentIdx = this._availableSources.indexOf(this._currentSource);
         const nextIdx = (currentIdx + 1) % this._availableSources.length;
         const nextSource = this._availableSources[nextIdx];
-        const savedTime = this.videoEl.currentTime;
-
-        this._cleanupVideo();
-        this.videoEl.removeAttribute('src');
-        this.videoEl.load();
-
-        try {
-            const data = await this._requestSource(item, nextSource, this.selectedAudioIndex);
-            this.currentSessionId = data.sessionId;
-            this.videoDuration = data.duration || null;
-            this.availableSubtitles = data.subtitles || [];
-            this._currentSource = data.source;
-            this.videoEl.classList.remove('hidden');
-            while (this.videoEl.firstChild) this.videoEl.removeChild(this.videoEl.firstChild);
-
-            const seekOnce = () => { this.videoEl.currentTime = savedTime; this.videoEl.removeEventListener('loadeddata', seekOnce); };
-            if (savedTime > 0) this.videoEl.addEventListener('loadeddata', seekOnce);
-
-            if (data.mode === 'direct') {
-                this._directMode = true;
-                this.videoEl.src = data.url;
-                await this.videoEl.play();
-            } else {
-                this._directMode = false;
-                this._loadVideoHLS(data.url);
-            }
-
-            UI.updateSubtitleButton(this.availableSubtitles.length > 0);
-            UI.updateSourceButton(this._currentSource, true);
-            if (this.activeSubtitleIndex !== null) this.setSubtitle(this.activeSubtitleIndex);
-        } catch (e) {
-            console.error('Source cycle failed', e);
-            UI.showError(e.message || 'Could not switch playback source');
-        }
+        await this._reloadStream(nextSource, this.selectedAudioIndex, this._videoTime(), 'Could not switch playback source');
     },
 
     async setAudioTrack(index) {
         if (!this.queue.length) return;
         const item = this.queue[this.currentIndex];
         if (!item || item.type !== 'video') return;
-        const savedTime = this.videoEl.currentTime;
+        // Tracks carried as renditions switch in place.
+        const rendition = this._audioRenditions.indexOf(index);
+        if (this.hls && rendition >= 0) {
+            this.hls.audioTrack = rendition;
+            this.selectedAudioIndex = index;
+            return;
+        }
+        await this._reloadStream(this._currentSource, index, this._videoTime(), 'Could not switch audio track');
+    },
+
+    // Restarts the current video with a different source, audio track or
+    // start position, resuming playback at startAt seconds.
+    async _reloadStream(source, audioIndex, startAt, errorMessage = 'Could not restart playback') {
+        const item = this.queue[this.currentIndex];
+        if (!item || item.type !== 'video') return;
+        const progressItem = this._progressItem;
 
         this._cleanupVideo();
         this.videoEl.removeAttribute('src');
         this.videoEl.load();
 
         try {
-            const data = await this._requestSource(item, this._currentSource, index);
+            const data = await this._requestSource(item, source, audioIndex, startAt);
+            this._progressItem = progressItem;
+            this._timeOffset = data.startOffset || 0;
             this.currentSessionId = data.sessionId;
             this.videoDuration = data.duration || null;
             this.availableSubtitles = data.subtitles || [];
             this._currentSource = data.source;
-            this.selectedAudioIndex = index;
-            this.availableAudioTracks = data.audioTracks || [];
+            if (audioIndex != null) this.selectedAudioIndex = audioIndex;
+            this._audioRenditions = data.audioRenditions || [];
+            this.availableAudioTracks = data.audioTracks || this.availableAudioTracks;
             this.videoEl.classList.remove('hidden');
             while (this.videoEl.firstChild) this.videoEl.removeChild(this.videoEl.firstChild);
 
-            const seekOnce = () => { this.videoEl.currentTime = savedTime; this.videoEl.removeEventListener('loadeddata', seekOnce); };
-            if (savedTime > 0) this.videoEl.addEventListener('loadeddata', seekOnce);
+            this._seekOnLoad(startAt);
 
             if (data.mode === 'direct') {
                 this._directMode = true;
@@ -551,7 +635,7 @@ const Player = {
                 await this.videoEl.play();
             } else {
                 this._directMode = false;
-                this._loadVideoHLS(data.url);
+                this._loadVideoHLS(data.url, startAt);
             }
 
             UI.updateSubtitleButton(this.availableSubtitles.length > 0);
@@ -559,25 +643,98 @@ const Player = {
             UI.updateSourceButton(this._currentSource, true);
             if (this.activeSubtitleIndex !== null) this.setSubtitle(this.activeSubtitleIndex);
         } catch (e) {
-            console.error('Audio track switch failed', e);
-            UI.showError(e.message || 'Could not switch audio track');
+            console.error('Stream reload failed', e);
+            UI.showError(e.message || errorMessage);
         }
     },
 
     setSubtitle(index) {
         if (!this.videoEl || !this.currentSessionId) return;
-        this.videoEl.querySelectorAll('track').forEach(t => t.remove());
-        if (index !== null && index > 0) {
-            const track = document.createElement('track');
-            track.kind = 'subtitles';
-            track.label = `Sub ${index}`;
-            track.srclang = 'en';
-            track.src = `/api/hls/${this.currentSessionId}/sub_${index}.vtt`;
-            track.default = true;
-            this.videoEl.appendChild(track);
-            track.track.mode = 'showing';
+        const sub = this.availableSubtitles.find(s => s.index === index);
+        // Bitmap subtitles are burned in by the server, so switching to or
+        // away from one restarts the stream.
+        const burn = sub && sub.burnIn ? index : null;
+        if (burn !== this._burnSubtitle) {
+            this._burnSubtitle = burn;
+            this.activeSubtitleIndex = index;
+            this._reloadStream(this._currentSource, this.selectedAudioIndex, this._videoTime(), 'Could not switch subtitles');
+            return;
         }
+        this.videoEl.querySelectorAll('track').forEach(t => t.remove());
+        this._destroyASS();
         this.activeSubtitleIndex = index;
+        if (index === null || index <= 0 || burn) return;
+        // ASS tracks keep their typesetting when the browser can run the
+        // libass renderer, and fall back to the WebVTT conversion otherwise.
+        if (sub && sub.format === 'ass' && window.JASSUB && this._progressItem) {
+            this._loadASS(sub).catch(e => {
+                console.warn('Styled subtitles unavailable, using WebVTT', e);
+                if (this.activeSubtitleIndex === index) this._addVTTTrack(sub, index);
+            });
+            return;
+        }
+        this._addVTTTrack(sub, index);
+    },
+
+    _addVTTTrack(sub, index) {
+        const track = document.createElement('track');
+        track.kind = 'subtitles';
+        track.label = sub ? sub.label : `Sub ${index}`;
+        track.srclang = (sub && sub.language) || 'en';
+        // The offset makes a new URL whenever it changes, as the file does.
+        track.src = `/api/hls/${this.currentSessionId}/sub_${index}.vtt?offset=${(sub && sub.offset) || 0}`;
+        track.default = true;
+        this.videoEl.appendChild(track);
+        track.track.mode = 'showing';
+    },
+
+    async _loadASS(sub) {
+        const params = new URLSearchParams({ file: this._progressItem.path, mode: this._progressItem.mode });
+        const res = await API.request(`/api/subtitle/fonts?${params}`);
+        if (!res.ok) throw new Error(await res.text());
+        const fonts = await res.json();
+        // The selection may have moved on while the fonts were listed.
+        if (this.activeSubtitleIndex !== sub.index) return;
+        params.set('track', sub.index);
+        this._assRenderer = new JASSUB({
+            video: this.videoEl,
+            subUrl: `/api/subtitle/ass?${params}`,
+            fonts: fonts.map(f => f.url),
+            workerUrl: '/static/js/jassub-worker.js',
+            wasmUrl: '/static/js/jassub-worker.wasm',
+            availableFonts: { 'liberation sans': '/static/js/default.woff2' },
+            // Streams started mid-file play from zero; cues keep file time,
+            // plus whatever offset the track is set to.
+            timeOffset: this._timeOffset - (sub.offset || 0),
+        });
+    },
+
+    // Moves the active subtitle track by delta seconds, positive delaying
+    // it; the offset is kept on the server for every later play.
+    async adjustSubtitleOffset(delta) {
+        const sub = this.availableSubtitles.find(s => s.index === this.activeSubtitleIndex);
+        if (!sub || sub.burnIn || !this._progressItem) return;
+        const offset = Math.round(((sub.offset || 0) + delta) * 10) / 10;
+        try {
+            const res = await API.request('/api/subtitle', {
+                method: 'PUT',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ mode: this._progressItem.mode, file: this._progressItem.path, track: sub.index, offset }),
+            });
+            if (!res.ok) throw new Error(await res.text());
+            const offsets = new Map((await res.json()).map(s => [s.index, s.offset || 0]));
+            this.availableSubtitles.forEach(s => { s.offset = offsets.get(s.index) || 0; });
+            this.setSubtitle(this.activeSubtitleIndex);
+        } catch (e) {
+            UI.showError(e.message || 'Could not change subtitle timing');
+        }
+    },
+
+    _destroyASS() {
+        if (this._assRenderer) {
+            this._assRenderer.destroy();
+            this._assRenderer = null;
+        }
     }
 };
 
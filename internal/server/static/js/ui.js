@@ -10,6 +10,7 @@ const UI = {
     fullscreenControlsTimer: null,
     fullscreenControlsVisible: true,
     _toastTimer: null,
+    _feeds: null,
     
     init() {
         const handleRangeInput = (e) => {
@@ -121,6 +122,14 @@ const UI = {
             });
         }
 
+        const historyContainer = document.getElementById('history-list-container');
+        if (historyContainer) {
+            historyContainer.addEventListener('click', (e) => {
+                const item = e.target.closest('[data-feed-index]');
+                if (item) this.playFeedItem(item.dataset.feedSection, parseInt(item.dataset.feedIndex, 10));
+            });
+        }
+
         // Handle fullscreen change events (for escape key)
         document.addEventListener('fullscreenchange', () => this.handleFullscreenChange());
         document.addEventListener('webkitfullscreenchange', () => this.handleFullscreenChange());
@@ -429,24 +438,52 @@ const UI = {
         }
     },
     
-    renderHistoryList() {
+    async renderHistoryList() {
         const container = document.getElementById('history-list-container');
         if (!container) return;
-        
+
+        const mode = state.mode;
+        const [continueItems, recentItems] = await Promise.all([
+            mode === 'music' ? [] : API.feed('continue', mode, 10),
+            API.feed('recent', mode, 10),
+        ]);
+        this._feeds = { continue: continueItems, recent: recentItems };
+
+        let html = '';
+        if (continueItems.length) {
+            html += Elements.createFeedHeader('Continue Watching');
+            html += continueItems.map((item, idx) => Elements.createFeedItem(item, 'continue', idx)).join('');
+        }
+        if (recentItems.length) {
+            html += Elements.createFeedHeader('Recently Added');
+            html += recentItems.map((item, idx) => Elements.createFeedItem(item, 'recent', idx)).join('');
+        }
+
         try {
             const history = JSON.parse(localStorage.getItem('raikiri_history') || '[]');
+            html += Elements.createFeedHeader('History');
             if (history.length === 0) {
-                container.innerHTML = '<div class="p-4 text-center text-subtext0 text-sm">No history</div>';
+                html += '<div class="p-4 text-center text-subtext0 text-sm">No history</div>';
             } else {
-                container.innerHTML = history.map((path, idx) => 
+                html += history.map((path, idx) => 
                     Elements.createHistoryItem(path, idx)
                 ).join('');
-                this.refreshIcons();
             }
         } catch (e) {
             console.error('Failed to load history', e);
-            container.innerHTML = '<div class="p-4 text-center text-subtext0 text-sm">Error loading history</div>';
+            html += '<div class="p-4 text-center text-subtext0 text-sm">Error loading history</div>';
         }
+        container.innerHTML = html;
+        this.refreshIcons();
+    },
+
+    playFeedItem(section, idx) {
+        const items = (this._feeds && this._feeds[section]) || [];
+        if (!items[idx]) return;
+        // Recently added items queue up together; continue entries play on their own.
+        const queue = section === 'recent' ? items : [items[idx]];
+        Player.setQueue(queue, section === 'recent' ? idx : 0);
+        this.toggleHistoryDialog();
     },
 
     renderSubtitleList() {
@@ -472,7 +509,7 @@ const UI = {
             option.className = 'flex items-center gap-3 p-3 rounded-lg hover:bg-surface0 cursor-pointer transition-colors';
             option.innerHTML = `
                 <input type="radio" name="subtitle" value="${sub.index}" ${Player.activeSubtitleIndex === sub.index ? 'checked' : ''} class="w-4 h-4 text-mauve">
-                <span class="text-text">${Escape.html(sub.label)}</span>
+                <span class="text-text">${Escape.html(sub.burnIn ? `${sub.label} (burned in)` : sub.label)}</span>
             `;
             option.querySelector('input').addEventListener('change', () => {
                 Player.setSubtitle(sub.index);
@@ -480,6 +517,32 @@ const UI = {
             });
             container.appendChild(option);
         });
+
+        // Timing of the active track, for subtitles out of sync.
+        const active = Player.availableSubtitles.find(s => s.index === Player.activeSubtitleIndex);
+        if (active && !active.burnIn) {
+            const timing = document.createElement('div');
+            timing.className = 'flex items-center justify-between gap-2 p-3 border-t border-surface0 text-sm';
+            const offset = active.offset || 0;
+            timing.innerHTML = `
+                <span class="text-subtext0">Delay</span>
+                <div class="flex items-center gap-1">
+                    <button data-delta="-1" class="px-2 py-1 rounded hover:bg-surface0 text-subtext0 hover:text-mauve">-1s</button>
+                    <button data-delta="-0.1" class="px-2 py-1 rounded hover:bg-surface0 text-subtext0 hover:text-mauve">-0.1</button>
+                    <span class="w-14 text-center text-text tabular-nums">${offset > 0 ? '+' : ''}${offset.toFixed(1)}s</span>
+                    <button data-delta="0.1" class="px-2 py-1 rounded hover:bg-surface0 text-subtext0 hover:text-mauve">+0.1</button>
+                    <button data-delta="1" class="px-2 py-1 rounded hover:bg-surface0 text-subtext0 hover:text-mauve">+1s</button>
+                </div>
+            `;
+            timing.querySelectorAll('button').forEach(btn => {
+                btn.addEventListener('click', async (e) => {
+                    e.stopPropagation();
+                    await Player.adjustSubtitleOffset(parseFloat(btn.dataset.delta));
+                    this.renderSubtitleList();
+                });
+            });
+            container.appendChild(timing);
+        }
     },
 
     renderAudioList() {
@@ -489,7 +552,7 @@ const UI = {
         container.innerHTML = '';
 
         Player.availableAudioTracks.forEach((track, i) => {
-            const label = `Track ${i + 1} (${track.language}, ${track.channels}ch)`;
+            const label = track.label || `Track ${i + 1} (${track.language}, ${track.channels}ch)`;
             const option = document.createElement('label');
             option.className = 'flex items-center gap-3 p-3 rounded-lg hover:bg-surface0 cursor-pointer transition-colors';
             option.innerHTML = `
@@ -505,7 +568,7 @@ const UI = {
     },
 
     updateSourceButton(source, visible) {
-        const labels = { 'direct': 'Direct', 'remux': 'Remux', 'hls-fmp4': 'HLS', 'hls-ts': 'HLS-TS' };
+        const labels = { 'direct': 'Direct', 'optimized': 'Optimized', 'remux': 'Remux', 'hls-fmp4': 'HLS', 'hls-ts': 'HLS-TS', 'hls-abr': 'Auto' };
         const label = labels[source] || '';
 
         const desktopBtn = document.getElementById('ep-source-btn-desktop');
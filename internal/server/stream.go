@@ -1,67 +1,172 @@
 package server
 
 import (
+	"context"
+	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/tanq16/raikiri/internal/auth"
+	"github.com/tanq16/raikiri/internal/cache"
 	"github.com/tanq16/raikiri/internal/media"
 )
 
-func extractSubtitles(fullPath, sessionDir string) []map[string]interface{} {
-	var subtitleList []map[string]interface{}
-	subtitleCounter := 1
-
-	externalSubs := media.FindExternalSubtitles(fullPath)
-	for _, subPath := range externalSubs {
-		dstPath := filepath.Join(sessionDir, fmt.Sprintf("sub_%d.vtt", subtitleCounter))
-		if err := media.ConvertSRTtoVTT(subPath, dstPath); err == nil {
-			subtitleList = append(subtitleList, map[string]interface{}{
-				"index": subtitleCounter,
-				"label": fmt.Sprintf("Sub %d", subtitleCounter),
-			})
-			subtitleCounter++
-		} else {
-			log.Printf("ERROR [server] failed to convert external subtitle path=%s: %v", subPath, err)
-		}
+const (
+	// How long starting a stream waits for ffmpeg's first segment.
+	streamStartWait = 10 * time.Second
+	// How long starting a copy stream waits for keyframes of a file that has
+	// not been streamed before.
+	keyframeWait = 3 * time.Second
+)
+
+// subtitleInfo is a subtitle track as clients see it; the track itself is
+// sub_<index>.vtt in the stream directory.
+type subtitleInfo struct {
+	Index    int    `json:"index"`
+	Label    string `json:"label"`
+	Language string `json:"language,omitempty"`
+	Default  bool   `json:"default,omitempty"`
+	Forced   bool   `json:"forced,omitempty"`
+	// BurnIn marks bitmap subtitles: players pass their index as burn to
+	// /api/stream to have them burned into the video.
+	BurnIn bool `json:"burnIn,omitempty"`
+	// Format is "ass" for ASS/SSA tracks, which players with a libass
+	// renderer can fetch as they are from /api/subtitle/ass.
+	Format string `json:"format,omitempty"`
+	// Offset is the timing correction set for the track through
+	// /api/subtitle, in seconds; WebVTT files come with it applied.
+	Offset float64 `json:"offset,omitempty"`
+}
+
+// listSubtitles finds the external and embedded text subtitles of a video.
+// Nothing is converted yet: each track is turned into WebVTT when a player
+// first asks for it, and kept for later plays.
+func listSubtitles(fullPath string) ([]subtitleInfo, []media.SubtitleTrack) {
+	var tracks []media.SubtitleTrack
+	for _, subPath := range media.FindExternalSubtitles(fullPath) {
+		tracks = append(tracks, media.ExternalSubtitleTrack(fullPath, subPath))
 	}
+	tracks = append(tracks, media.GetEmbeddedSubtitleTracks(fullPath)...)
+
+	subtitleList := make([]subtitleInfo, 0, len(tracks))
+	for i, track := range tracks {
+		subtitleList = append(subtitleList, subtitleInfo{
+			Index:    i + 1,
+			Label:    media.SubtitleLabel(track, fmt.Sprintf("Sub %d", i+1)),
+			Language: track.Language,
+			Default:  track.Default,
+			Forced:   track.Forced,
+			BurnIn:   track.BurnIn,
+			Format:   subtitleFormat(track),
+		})
+	}
+	return subtitleList, tracks
+}
 
-	embeddedSubs := media.GetEmbeddedSubtitleTracks(fullPath)
-	for _, track := range embeddedSubs {
-		dstPath := filepath.Join(sessionDir, fmt.Sprintf("sub_%d.vtt", subtitleCounter))
-		if err := media.ExtractSubtitleToSRT(fullPath, track.Index, dstPath); err == nil {
-			subtitleList = append(subtitleList, map[string]interface{}{
-				"index": subtitleCounter,
-				"label": fmt.Sprintf("Sub %d", subtitleCounter),
-			})
-			subtitleCounter++
-		} else {
-			log.Printf("ERROR [server] failed to extract embedded subtitle track=%d: %v", track.Index, err)
-		}
+func subtitleFormat(track media.SubtitleTrack) string {
+	if track.Styled() {
+		return "ass"
 	}
+	return ""
+}
 
-	return subtitleList
+// withOffsets returns a copy of the subtitles of a video with the offsets
+// currently set for them.
+func (s *Server) withOffsets(videoPath string, subtitles []subtitleInfo, tracks []media.SubtitleTrack) []subtitleInfo {
+	list := slices.Clone(subtitles)
+	for i := range list {
+		list[i].Offset = s.subtitleSync.Offset(videoPath, tracks[i].ID())
+	}
+	return list
+}
+
+// subtitleFile returns the WebVTT file of subtitle n of stream, converting
+// it on first request. Streams that start mid-file and tracks with an
+// offset get a copy shifted to match in the stream directory.
+func (s *Server) subtitleFile(stream *streamSession, n int) (string, error) {
+	if n < 1 || n > len(stream.subtitleTracks) || stream.subtitleTracks[n-1].BurnIn {
+		return "", os.ErrNotExist
+	}
+	track := stream.subtitleTracks[n-1]
+	path, err := media.CachedSubtitle(stream.input, track)
+	shift := stream.subtitleOffset - s.subtitleSync.Offset(stream.input, track.ID())
+	if err != nil || shift == 0 {
+		return path, err
+	}
+	shifted := filepath.Join(stream.dir, fmt.Sprintf("sub_%d_%d.vtt", n, int64(math.Round(shift*1000))))
+	if _, err := os.Stat(shifted); err == nil {
+		return shifted, nil
+	}
+	if err := media.ShiftVTT(path, shifted, shift); err != nil {
+		return "", err
+	}
+	return shifted, nil
+}
+
+// burnSubtitle rewrites ffmpeg output arguments to overlay subtitle stream
+// index on the video before any other video filter, so bitmap subtitles
+// are scaled along with the picture.
+func burnSubtitle(args []string, index int) []string {
+	graph := fmt.Sprintf("[0:v:0][0:%d]overlay=eof_action=pass", index)
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "-map" && i+1 < len(args) && args[i+1] == "0:v:0":
+			i++
+		case args[i] == "-vf" && i+1 < len(args):
+			graph += "," + args[i+1]
+			i++
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	return append([]string{"-filter_complex", graph + "[v]", "-map", "[v]"}, rest...)
 }
 
 func (s *Server) HandleStreamStart(w http.ResponseWriter, r *http.Request) {
 	targetFile := r.URL.Query().Get("file")
 	mode := r.URL.Query().Get("mode")
-	source := r.URL.Query().Get("source") // "direct", "remux", "hls-fmp4", "hls-ts", or "" (auto)
+	source := r.URL.Query().Get("source") // "direct", "optimized", "remux", "hls-fmp4", "hls-ts", or "" (auto)
 	forceHLS := r.URL.Query().Get("force") == "hls"
 	audioParam := r.URL.Query().Get("audio")
+	burnParam := r.URL.Query().Get("burn") // index of a bitmap subtitle to burn in
+	startParam := r.URL.Query().Get("start")
+	// Limits for viewing over slow links: output height in pixels and total
+	// bitrate in kbit/s.
+	maxHeight, _ := strconv.Atoi(r.URL.Query().Get("maxHeight"))
+	maxBitrate, _ := strconv.Atoi(r.URL.Query().Get("maxBitrate"))
 	fullPath, ok := s.resolveWithinRoot(mode, targetFile)
 	if !ok {
 		http.Error(w, "Invalid path", 400)
 		return
 	}
+	if !s.allowed(r, mode, targetFile, auth.PermRead) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// Clients POST their device profile; plain GETs get the default one.
+	profile := media.DefaultProfile()
+	if r.Method == http.MethodPost && r.ContentLength != 0 {
+		profile = &media.DeviceProfile{}
+		if err := json.NewDecoder(r.Body).Decode(profile); err != nil {
+			http.Error(w, "Invalid device profile", 400)
+			return
+		}
+		profile.Normalize()
+	}
 
 	duration, err := media.GetVideoDuration(fullPath)
 	if err != nil {
@@ -74,16 +179,13 @@ func (s *Server) HandleStreamStart(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	sessionID := fmt.Sprintf("s_%d", time.Now().UnixNano())
-	sessionDir := filepath.Join(s.config.CachePath, sessionID)
-	os.MkdirAll(sessionDir, 0755)
-
-	subtitleList := extractSubtitles(fullPath, sessionDir)
-	log.Printf("INFO [server] subtitles found count=%d session=%s", len(subtitleList), sessionID)
-
-	isServable := media.IsDirectServable(fullPath)
-	videoCodec := media.GetVideoCodec(fullPath)
-	canRemux := media.IsVideoCompatibleForHLS(videoCodec)
+	// Resume offset in seconds; ignored when it falls outside the video.
+	var start float64
+	if startParam != "" {
+		if v, err := strconv.ParseFloat(startParam, 64); err == nil && v > 0 && v < duration {
+			start = v
+		}
+	}
 
 	audioTracks := media.GetAudioTracks(fullPath)
 	defaultAudio := media.SelectBestAudioTrack(audioTracks)
@@ -99,14 +201,68 @@ func (s *Server) HandleStreamStart(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	isServable := profile.CanDirectPlay(fullPath, defaultAudio)
+	videoCodec := media.GetVideoCodec(fullPath)
+	hdr := media.IsHDR(fullPath)
+	canRemux := profile.CanCopyVideo(videoCodec, hdr)
+	// HDR the client cannot show is tone mapped whenever it is transcoded.
+	tonemap := hdr && !profile.DisplaysHDR()
+	srcWidth, srcHeight := media.GetVideoDimensions(fullPath)
+	srcBitrate := media.GetBitrate(fullPath) / 1000
+
+	// A source above the requested limits has to be scaled down, which rules
+	// out serving or copying it.
+	var capped *Rendition
+	if (maxHeight > 0 && srcHeight > maxHeight) || (maxBitrate > 0 && srcBitrate > maxBitrate) {
+		c := capRendition(srcHeight, maxHeight, maxBitrate)
+		capped = &c
+		isServable, canRemux = false, false
+		log.Printf("INFO [server] capping stream height=%d bitrate=%dk file=%s", c.Height, c.Bitrate, targetFile)
+	}
+
+	// Bitmap subtitles are burned into the video, which is then always
+	// transcoded.
+	var burn *media.SubtitleTrack
+	var burnIndex int
+	if n, err := strconv.Atoi(burnParam); err == nil {
+		_, tracks := listSubtitles(fullPath)
+		if n >= 1 && n <= len(tracks) && tracks[n-1].BurnIn {
+			burn, burnIndex = &tracks[n-1], n
+			isServable, canRemux = false, false
+			log.Printf("INFO [server] burning in subtitle track=%d codec=%s file=%s", burn.Index, burn.Codec, targetFile)
+		}
+	}
+
+	// A copy made by 'raikiri prepare optimize' is served as it is to
+	// clients that play it, as long as it fits the requested limits.
+	var optimizedPath string
+	var optimized StreamRendition
+	if !isServable && burn == nil {
+		if p, ok := media.FindOptimized(fullPath); ok {
+			optimized.Width, optimized.Height = media.GetVideoDimensions(p)
+			optimized.Bitrate = media.GetBitrate(p) / 1000
+			optimized.VideoCodec = media.GetVideoCodec(p)
+			if profile.CanDirectPlay(p, media.SelectBestAudioTrack(media.GetAudioTracks(p))) &&
+				(maxHeight <= 0 || optimized.Height <= maxHeight) && (maxBitrate <= 0 || optimized.Bitrate <= maxBitrate) {
+				optimizedPath = p
+			}
+		}
+	}
+
 	availableSources := []string{}
 	if isServable {
 		availableSources = append(availableSources, "direct")
 	}
+	if optimizedPath != "" {
+		availableSources = append(availableSources, "optimized")
+	}
 	if canRemux {
 		availableSources = append(availableSources, "remux")
 	}
 	availableSources = append(availableSources, "hls-fmp4", "hls-ts")
+	if len(s.config.Ladder) > 0 {
+		availableSources = append(availableSources, "hls-abr")
+	}
 
 	if source == "" {
 		if forceHLS {
@@ -117,6 +273,8 @@ func (s *Server) HandleStreamStart(w http.ResponseWriter, r *http.Request) {
 			}
 		} else if isServable {
 			source = "direct"
+		} else if optimizedPath != "" {
+			source = "optimized"
 		} else if canRemux {
 			source = "remux"
 		} else {
@@ -131,13 +289,21 @@ func (s *Server) HandleStreamStart(w http.ResponseWriter, r *http.Request) {
 			source = "hls-fmp4"
 		}
 	}
+	if source == "optimized" && optimizedPath == "" {
+		if canRemux {
+			source = "remux"
+		} else {
+			source = "hls-fmp4"
+		}
+	}
 	if source == "remux" && !canRemux {
 		source = "hls-fmp4"
 	}
 
-	// Direct mode serves the raw file and cannot honor a non-default audio track.
+	// Direct mode serves the raw file and cannot honor a non-default audio track;
+	// neither can an optimized copy, which only has the default one.
 	// Bump to remux (or hls-fmp4 if not remux-capable) when a different track is requested.
-	if audioParam != "" && source == "direct" && selectedAudio != nil && defaultAudio != nil && selectedAudio.Index != defaultAudio.Index {
+	if audioParam != "" && (source == "direct" || source == "optimized") && selectedAudio != nil && defaultAudio != nil && selectedAudio.Index != defaultAudio.Index {
 		if canRemux {
 			source = "remux"
 		} else {
@@ -145,43 +311,96 @@ func (s *Server) HandleStreamStart(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	if source == "direct" {
-		log.Printf("INFO [server] direct serve file=%s", targetFile)
+	if source == "hls-abr" && len(s.config.Ladder) == 0 {
+		source = "hls-fmp4"
+	}
 
-		if len(subtitleList) == 0 {
-			os.RemoveAll(sessionDir)
-			sessionID = ""
+	isRemux := source == "remux"
+	isTS := source == "hls-ts"
+	isABR := source == "hls-abr"
+	needsVideoTranscode := capped != nil || burn != nil || !profile.CanCopyVideo(videoCodec, hdr)
+	copyVideo := isRemux || !needsVideoTranscode
+
+	// HLS output gets a VOD playlist covering the whole file. Transcoded video
+	// is cut on a fixed grid; copied video can only be cut at its keyframes,
+	// which take a full read of the file to list. Until they are known the
+	// stream falls back to an event playlist starting at the resume point.
+	var plan []float64
+	var grid bool
+	var offset float64
+	if source != "direct" && source != "optimized" {
+		if copyVideo {
+			if keyframes, ok := media.Keyframes(fullPath, keyframeWait); ok {
+				plan = keyframeSegments(keyframes, media.GetStartTime(fullPath), duration)
+			}
+		}
+		if plan == nil && (!copyVideo || isABR) {
+			// Renditions of an adaptive stream must share segment boundaries,
+			// so without keyframes its source rendition is transcoded as well.
+			plan, grid = gridSegments(duration), true
+			copyVideo = false
+		}
+		if plan == nil {
+			log.Printf("INFO [server] keyframes not known yet, using event playlist file=%s", targetFile)
+			offset = start
+		}
+	}
+
+	if source == "direct" || source == "optimized" {
+		log.Printf("INFO [server] %s serve profile=%s file=%s", source, profile.Name, targetFile)
+
+		// Nothing runs for a direct session, but its subtitles are removed
+		// with it once the player stops heartbeating.
+		var sessionID string
+		var subtitleList []subtitleInfo
+		stream := &streamSession{
+			id:       fmt.Sprintf("s_%d", time.Now().UnixNano()),
+			variants: make(map[string]*hlsSession),
+		}
+		stream.dir = filepath.Join(s.config.CachePath, stream.id)
+		if v, err := s.openStream(r.Context(), userFromRequest(r), stream, 0, func() error { return s.setupStream(stream, fullPath, nil, 0, cache.Info{}) }); err != nil {
+			log.Printf("ERROR [server] failed to set up session: %v", err)
+		} else if len(stream.subtitles) == 0 {
+			s.stopSession(v.id)
+		} else {
+			sessionID, subtitleList = v.id, s.withOffsets(fullPath, stream.subtitles, stream.subtitleTracks)
 		}
 
 		segments := strings.Split(targetFile, "/")
 		for i, seg := range segments {
 			segments[i] = url.PathEscape(seg)
 		}
+		rendition := StreamRendition{Width: srcWidth, Height: srcHeight, Bitrate: srcBitrate, VideoCodec: videoCodec}
+		if source == "optimized" {
+			segments[len(segments)-1] = url.PathEscape(filepath.Base(optimizedPath))
+			rendition = optimized
+		}
 		contentURL := fmt.Sprintf("/content/%s?mode=%s", strings.Join(segments, "/"), mode)
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"mode":             "direct",
-			"source":           "direct",
+			"source":           source,
 			"url":              contentURL,
 			"duration":         duration,
+			"start":            start,
+			"startOffset":      0,
 			"sessionId":        sessionID,
 			"subtitles":        subtitleList,
 			"availableSources": availableSources,
 			"audioTracks":      audioTracks,
 			"selectedAudio":    audioTrackIndex(selectedAudio),
+			"rendition":        rendition,
 		})
 		return
 	}
 
-	isRemux := source == "remux"
-	isTS := source == "hls-ts"
-
-	needsVideoTranscode := !media.IsVideoCompatibleForHLS(videoCodec)
 	if isRemux {
 		log.Printf("INFO [server] remux mode: copying video codec=%s file=%s", videoCodec, targetFile)
+	} else if capped != nil {
+		log.Printf("INFO [server] transcoding to %s to honor stream limits codec=%s file=%s", capped.Name(), videoCodec, targetFile)
 	} else if needsVideoTranscode {
-		log.Printf("INFO [server] video codec not HLS-compatible, will transcode to H.264 codec=%s file=%s", videoCodec, targetFile)
+		log.Printf("INFO [server] video not playable by client, will transcode to H.264 codec=%s hdr=%t profile=%s file=%s", videoCodec, hdr, profile.Name, targetFile)
 	} else {
 		log.Printf("INFO [server] video codec HLS-compatible, will copy codec=%s file=%s", videoCodec, targetFile)
 	}
@@ -192,12 +411,11 @@ func (s *Server) HandleStreamStart(w http.ResponseWriter, r *http.Request) {
 		log.Printf("INFO [server] selected audio track=%d codec=%s lang=%s channels=%d file=%s", selectedAudio.Index, selectedAudio.Codec, selectedAudio.Language, selectedAudio.Channels, targetFile)
 
 		if isRemux {
-			canCopyAudio := selectedAudio.Codec == "aac" && selectedAudio.Channels <= 2
-			if canCopyAudio {
+			if profile.CanCopyAudio(selectedAudio) {
 				log.Printf("INFO [server] remux: copying compatible audio file=%s", targetFile)
 				audioArgs = append(audioArgs, "-c:a", "copy")
 			} else {
-				log.Printf("INFO [server] remux: re-encoding audio to AAC stereo file=%s", targetFile)
+				log.Printf("INFO [server] remux: re-encoding audio to AAC stereo profile=%s file=%s", profile.Name, targetFile)
 				audioArgs = append(audioArgs, "-c:a", "aac", "-b:a", "192k", "-ac", "2", "-ar", "48000")
 			}
 		} else if isTS {
@@ -210,6 +428,10 @@ func (s *Server) HandleStreamStart(w http.ResponseWriter, r *http.Request) {
 				log.Printf("INFO [server] HLS-TS: copying compatible audio file=%s", targetFile)
 				audioArgs = append(audioArgs, "-c:a", "copy")
 			}
+		} else if plan != nil {
+			// VOD keeps source timestamps, so audio must not be padded to zero.
+			log.Printf("INFO [server] HLS-fMP4: re-encoding audio to AAC 48kHz stereo file=%s", targetFile)
+			audioArgs = append(audioArgs, "-c:a", "aac", "-b:a", "192k", "-ac", "2", "-ar", "48000")
 		} else {
 			log.Printf("INFO [server] HLS-fMP4: re-encoding audio with aresample file=%s", targetFile)
 			audioArgs = append(audioArgs, "-c:a", "aac", "-b:a", "192k", "-ac", "2", "-af", "aresample=osr=48000:first_pts=0")
@@ -219,109 +441,352 @@ func (s *Server) HandleStreamStart(w http.ResponseWriter, r *http.Request) {
 		audioArgs = []string{"-map", "0:v:0"}
 	}
 
-	playlistPath := filepath.Join(sessionDir, "index.m3u8")
+	videoArgs := []string{"-c:v", "copy"}
+	rendition := StreamRendition{Width: srcWidth, Height: srcHeight, Bitrate: srcBitrate, VideoCodec: videoCodec}
+	switch {
+	case capped != nil:
+		videoArgs = transcodeArgs(capped.Height, capped.Bitrate, tonemap)
+		rendition = StreamRendition{
+			Width:      scaledWidth(srcWidth, srcHeight, capped.Height),
+			Height:     capped.Height,
+			Bitrate:    capped.Bitrate + hlsAudioBitrate,
+			VideoCodec: "h264",
+			Transcoded: true,
+		}
+	case !copyVideo:
+		videoArgs = transcodeArgs(0, 0, tonemap)
+		rendition.VideoCodec, rendition.Transcoded = "h264", true
+	}
+	// VOD fMP4 streams of files with several audio tracks carry each track as
+	// a rendition of its own, so players switch languages without restarting
+	// the stream; the video renditions then have no audio.
+	audioRenditions := plan != nil && !isTS && len(audioTracks) > 1
+	if audioRenditions {
+		audioArgs = []string{"-map", "0:v:0"}
+	}
 
-	args := []string{
-		"-loglevel", "warning",
-		"-start_at_zero",
-		"-i", fullPath,
+	// Directories are assigned once the stream is known to be new.
+	newHLS := func(name string, video []string, transcode bool) *hlsSession {
+		codecArgs := append(slices.Clone(audioArgs), video...)
+		if burn != nil {
+			codecArgs = burnSubtitle(codecArgs, burn.Index)
+		}
+		return &hlsSession{
+			input:     fullPath,
+			codecArgs: codecArgs,
+			transcode: transcode,
+			grid:      grid,
+			ts:        isTS,
+			starts:    plan,
+			duration:  duration,
+			offset:    offset,
+			complete:  make(map[int]bool),
+		}
 	}
-	args = append(args, audioArgs...)
-	if isRemux || !needsVideoTranscode {
-		args = append(args, "-c:v", "copy")
-	} else {
-		args = append(args,
-			"-c:v", "libx264",
-			"-preset", "fast",
-			"-crf", "23",
-		)
-	}
-
-	args = append(args,
-		"-avoid_negative_ts", "make_zero",
-		"-max_interleave_delta", "0",
-		"-max_muxing_queue_size", "4096",
-		"-f", "hls",
-		"-hls_time", "6",
-		"-hls_list_size", "0",
-		"-hls_playlist_type", "event",
-	)
-
-	if isTS {
-		segmentPath := filepath.Join(sessionDir, "seg_%03d.ts")
-		args = append(args,
-			"-hls_segment_type", "mpegts",
-			"-hls_segment_filename", segmentPath,
-		)
+
+	stream := &streamSession{variants: make(map[string]*hlsSession)}
+	// The player starts on the first rendition.
+	var firstName string
+	var infos []variantInfo
+	if isABR {
+		// The top rendition comes first, so players that start with the first
+		// variant begin at full quality, followed by every rung of the ladder
+		// below it. The top is the source, or its capped transcode.
+		top, topHeight := "source", srcHeight
+		if capped != nil {
+			top, topHeight = capped.Name(), capped.Height
+		}
+		firstName = top
+		stream.variants[top] = newHLS(top, videoArgs, !copyVideo)
+		infos = []variantInfo{{name: top, width: rendition.Width, height: rendition.Height}}
+		for _, r := range s.config.Ladder {
+			if (topHeight > 0 && r.Height >= topHeight) || (capped != nil && r.Bitrate >= capped.Bitrate) {
+				continue
+			}
+			stream.variants[r.Name()] = newHLS(r.Name(), transcodeArgs(r.Height, r.Bitrate, tonemap), true)
+			infos = append(infos, variantInfo{
+				name:      r.Name(),
+				bandwidth: (r.Bitrate + hlsAudioBitrate) * 1000,
+				width:     scaledWidth(srcWidth, srcHeight, r.Height),
+				height:    r.Height,
+			})
+		}
+		infos[0].bandwidth = rendition.Bitrate * 1000
+		if len(infos) > 1 {
+			infos[0].bandwidth = max(infos[0].bandwidth, infos[1].bandwidth+1)
+		}
+	} else if audioRenditions {
+		firstName = "video"
+		stream.variants[firstName] = newHLS(firstName, videoArgs, !copyVideo)
+		infos = []variantInfo{{name: firstName, bandwidth: max(rendition.Bitrate, hlsAudioBitrate) * 1000, width: rendition.Width, height: rendition.Height}}
 	} else {
-		segmentPath := filepath.Join(sessionDir, "seg_%03d.m4s")
-		args = append(args,
-			"-hls_segment_type", "fmp4",
-			"-hls_fmp4_init_filename", "init.mp4",
-			"-hls_segment_filename", segmentPath,
-		)
+		stream.variants[""] = newHLS("", videoArgs, !copyVideo)
+		if plan != nil {
+			// A master playlist is added when there are subtitles to list.
+			infos = []variantInfo{{bandwidth: max(rendition.Bitrate, hlsAudioBitrate) * 1000, width: rendition.Width, height: rendition.Height}}
+		}
+	}
+	if audioRenditions {
+		// The default track comes first; it is what index.m3u8 starts on.
+		tracks := []*media.AudioTrack{defaultAudio}
+		for i := range audioTracks {
+			if audioTracks[i].Index != defaultAudio.Index {
+				tracks = append(tracks, &audioTracks[i])
+			}
+		}
+		labels := make(map[string]bool)
+		for _, track := range tracks {
+			codec := []string{"-c:a", "aac", "-b:a", "192k", "-ac", "2", "-ar", "48000"}
+			channels := 2
+			if isRemux && profile.CanCopyAudio(track) {
+				codec, channels = []string{"-c:a", "copy"}, track.Channels
+			}
+			name := fmt.Sprintf("audio_%d", track.Index)
+			stream.variants[name] = &hlsSession{
+				input:     fullPath,
+				codecArgs: append([]string{"-map", fmt.Sprintf("0:%d", track.Index)}, codec...),
+				audio:     true,
+				grid:      true,
+				starts:    gridSegments(duration),
+				duration:  duration,
+				complete:  make(map[int]bool),
+			}
+			// Names must be unique within the group.
+			label := track.Label
+			if labels[label] {
+				label = fmt.Sprintf("%s [%d]", label, track.Index)
+			}
+			labels[label] = true
+			stream.audio = append(stream.audio, audioInfo{name: name, index: track.Index, label: label, language: track.Language, channels: channels})
+		}
 	}
-	args = append(args, playlistPath)
 
-	cmd := exec.Command("ffmpeg", args...)
-	cmd.Stderr = os.Stderr
+	// VOD output only depends on what goes into it, so it is shared with
+	// other viewers and kept for replays. Event playlists start wherever
+	// their viewer resumed and are not.
+	stream.id = fmt.Sprintf("s_%d", time.Now().UnixNano())
+	if plan != nil {
+		// With audio renditions every track is in the stream, whichever the
+		// viewer starts on.
+		keyAudio := audioTrackIndex(selectedAudio)
+		if audioRenditions {
+			keyAudio = -1
+		}
+		if stream.key, err = transcodeKey(fullPath, source, keyAudio, stream.variants); err != nil {
+			log.Printf("ERROR [server] failed to key stream file=%s: %v", targetFile, err)
+			http.Error(w, "Failed to start stream", 500)
+			return
+		}
+		stream.id = stream.key
+	}
+	stream.dir = filepath.Join(s.config.CachePath, stream.id)
+	for name, h := range stream.variants {
+		h.id, h.dir = path.Join(stream.id, name), filepath.Join(stream.dir, name)
+	}
 
-	if err := cmd.Start(); err != nil {
-		log.Printf("ERROR [server] failed to start ffmpeg: %v", err)
+	// Direct and VOD playback seek client-side, so only event playlists
+	// shift their subtitles.
+	info := cache.Info{File: fullPath, Source: source, Audio: audioTrackIndex(selectedAudio)}
+	// New streams run ffmpeg, so they wait their turn. An adaptive stream
+	// encodes one rendition at a time and counts as one transcode.
+	weight := transcodeWeight
+	if copyVideo {
+		weight = copyWeight
+	}
+	v, err := s.openStream(r.Context(), userFromRequest(r), stream, weight, func() error { return s.setupStream(stream, fullPath, infos, offset, info) })
+	if isLimitError(err) {
+		writeLimitError(w, err)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR [server] failed to set up stream session=%s: %v", stream.id, err)
 		http.Error(w, "Failed to start stream", 500)
 		return
 	}
+	stream = v.stream
+	first := stream.variants[firstName]
+	sessionID := v.id
 
-	log.Printf("INFO [server] started HLS stream session=%s source=%s file=%s", sessionID, source, targetFile)
+	segment := 0
+	if first.vod() {
+		segment = first.segmentAt(start)
+	} else {
+		if start > 0 {
+			log.Printf("INFO [server] starting stream at offset=%.1fs file=%s", start, targetFile)
+		}
+		first.mu.Lock()
+		err = first.startLocked(sessionID, 0)
+		first.mu.Unlock()
+		if err != nil {
+			log.Printf("ERROR [server] %v", err)
+			s.stopSession(sessionID)
+			http.Error(w, "Failed to start stream", 500)
+			return
+		}
+	}
 
-	s.streamMutex.Lock()
-	s.activeStreams[sessionID] = cmd
-	s.streamMutex.Unlock()
+	log.Printf("INFO [server] started HLS stream session=%s viewer=%s source=%s vod=%t segment=%d file=%s", stream.id, sessionID, source, first.vod(), segment, targetFile)
 
-	var firstSegReady bool
-	if isTS {
-		firstSegReady = media.WaitForFile(filepath.Join(sessionDir, "seg_000.ts"), 50, 200*time.Millisecond) &&
-			media.WaitForFile(playlistPath, 50, 200*time.Millisecond)
-	} else {
-		firstSegReady = media.WaitForFile(filepath.Join(sessionDir, "init.mp4"), 50, 200*time.Millisecond) &&
-			media.WaitForFile(filepath.Join(sessionDir, "seg_000.m4s"), 50, 200*time.Millisecond) &&
-			media.WaitForFile(playlistPath, 50, 200*time.Millisecond)
+	// The player starts on the selected audio rendition along with video.
+	waitFor := []*hlsSession{first}
+	if h := stream.variants[fmt.Sprintf("audio_%d", audioTrackIndex(selectedAudio))]; h != nil && len(stream.audio) > 0 {
+		waitFor = append(waitFor, h)
 	}
-	if !firstSegReady {
-		log.Printf("INFO [server] HLS not ready, killing ffmpeg session=%s", sessionID)
-		s.streamMutex.Lock()
-		if cmd, exists := s.activeStreams[sessionID]; exists {
-			cmd.Process.Kill()
-			cmd.Wait()
-			delete(s.activeStreams, sessionID)
+	for _, h := range waitFor {
+		if err = h.waitSegment(sessionID, h.segmentAt(start), streamStartWait); err == nil && !isTS {
+			err = h.waitInit(sessionID, streamStartWait)
 		}
-		s.streamMutex.Unlock()
-		go func() {
-			if err := os.RemoveAll(sessionDir); err != nil {
-				log.Printf("ERROR [server] failed to remove session dir=%s: %v", sessionDir, err)
-			}
-		}()
+		if err != nil {
+			break
+		}
+	}
+	if err != nil {
+		log.Printf("INFO [server] HLS not ready, killing ffmpeg session=%s: %v", stream.id, err)
+		s.stopSession(sessionID)
 		http.Error(w, "Stream not ready", http.StatusServiceUnavailable)
 		return
 	}
 
-	log.Printf("INFO [server] HLS ready session=%s source=%s", sessionID, source)
+	log.Printf("INFO [server] HLS ready session=%s viewer=%s source=%s", stream.id, sessionID, source)
+
+	// Players switch between audio renditions themselves; they are listed
+	// in the order of the master playlist.
+	playlist := "index.m3u8"
+	if stream.master != "" {
+		playlist = masterPlaylist(stream.master, stream.audio, audioTrackIndex(selectedAudio))
+	}
+	var renditionAudio []int
+	for _, a := range stream.audio {
+		renditionAudio = append(renditionAudio, a.index)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"mode":             "hls",
 		"source":           source,
-		"url":              fmt.Sprintf("/api/hls/%s/index.m3u8", sessionID),
-		"altUrl":           fmt.Sprintf("/hls/%s/index.m3u8", sessionID),
+		"url":              fmt.Sprintf("/api/hls/%s/%s", sessionID, playlist),
+		"altUrl":           fmt.Sprintf("/hls/%s/%s", sessionID, playlist),
 		"sessionId":        sessionID,
 		"duration":         duration,
-		"subtitles":        subtitleList,
+		"start":            start,
+		"startOffset":      offset,
+		"subtitles":        s.withOffsets(fullPath, stream.subtitles, stream.subtitleTracks),
 		"availableSources": availableSources,
 		"audioTracks":      audioTracks,
 		"selectedAudio":    audioTrackIndex(selectedAudio),
+		"audioRenditions":  renditionAudio,
+		"burnSubtitle":     burnIndex,
+		"rendition":        rendition,
 	})
 }
 
+// openStream attaches a new viewer of user to stream, or to the stream with
+// the same id that other viewers already have open. The first viewer takes
+// the transcode capacity the stream needs, weight, and sets it up with
+// setup; the others wait for it to finish. Each viewer of a stream that
+// needs capacity counts against its user's limit; streams that need none,
+// such as the subtitles of direct play, count against neither.
+func (s *Server) openStream(ctx context.Context, user string, stream *streamSession, weight int, setup func() error) (*viewer, error) {
+	v := &viewer{id: "v_" + rand.Text(), user: user, stream: stream, limited: weight > 0}
+	if v.limited {
+		if err := s.limiter.join(user); err != nil {
+			return nil, err
+		}
+	}
+	v.heartbeat()
+	s.streamMutex.Lock()
+	existing := s.activeStreams[stream.id]
+	if existing != nil {
+		v.stream = existing
+	} else {
+		stream.ready = make(chan struct{})
+		s.activeStreams[stream.id] = stream
+	}
+	v.stream.refs++
+	s.viewers[v.id] = v
+	s.streamMutex.Unlock()
+
+	if existing == nil {
+		if weight > 0 {
+			stream.slot, stream.err = s.limiter.acquire(ctx, weight, queueWait)
+		}
+		if stream.err == nil {
+			stream.err = setup()
+		}
+		close(stream.ready)
+	} else {
+		log.Printf("INFO [server] joining stream session=%s viewer=%s", existing.id, v.id)
+	}
+	<-v.stream.ready
+	if v.stream.err != nil {
+		s.stopSession(v.id)
+		return nil, v.stream.err
+	}
+	return v, nil
+}
+
+// setupStream prepares the directory of a stream no viewer has open: the
+// playlists, the segments an earlier playback left behind and the subtitles.
+// Shared streams also record what they were made from.
+func (s *Server) setupStream(stream *streamSession, fullPath string, infos []variantInfo, subtitleOffset float64, info cache.Info) error {
+	if err := os.MkdirAll(stream.dir, 0755); err != nil {
+		return err
+	}
+	// The cache evicts by last use, read from the directory's times.
+	now := time.Now()
+	os.Chtimes(stream.dir, now, now)
+	if stream.key != "" {
+		if err := cache.WriteInfo(stream.dir, info); err != nil {
+			return err
+		}
+	}
+	for _, h := range stream.variants {
+		if !h.vod() {
+			continue
+		}
+		if err := os.MkdirAll(h.dir, 0755); err != nil {
+			return err
+		}
+		if err := h.load(); err != nil {
+			return err
+		}
+		if len(h.complete) > 0 {
+			log.Printf("INFO [server] reusing cached segments count=%d session=%s", len(h.complete), h.id)
+		}
+		if err := h.writePlaylist(); err != nil {
+			return fmt.Errorf("failed to write playlist: %w", err)
+		}
+	}
+	stream.input, stream.subtitleOffset = fullPath, subtitleOffset
+	stream.subtitles, stream.subtitleTracks = listSubtitles(fullPath)
+	log.Printf("INFO [server] subtitles found count=%d session=%s", len(stream.subtitles), stream.id)
+	// VOD streams also list their subtitles as renditions for native HLS
+	// players. A single stream keeps its playlist as index.m3u8 and gets a
+	// master playlist beside it only then.
+	if infos != nil && (infos[0].name != "" || len(stream.subtitles) > 0) {
+		stream.master = "index"
+		if infos[0].name == "" {
+			stream.master = "master"
+		}
+		if len(stream.subtitles) > 0 {
+			duration := stream.variants[infos[0].name].duration
+			for _, sub := range stream.subtitles {
+				if sub.BurnIn {
+					continue
+				}
+				if err := writeSubtitlePlaylist(stream.dir, sub.Index, duration); err != nil {
+					return fmt.Errorf("failed to write subtitle playlist: %w", err)
+				}
+			}
+		}
+		if err := writeMasterPlaylists(stream.dir, stream.master, infos, stream.audio, stream.subtitles); err != nil {
+			return fmt.Errorf("failed to write master playlist: %w", err)
+		}
+		log.Printf("INFO [server] master playlist renditions=%d audio=%d subtitles=%d session=%s", len(infos), len(stream.audio), len(stream.subtitles), stream.id)
+	}
+	return nil
+}
+
 func audioTrackIndex(t *media.AudioTrack) int {
 	if t == nil {
 		return -1
@@ -329,41 +794,209 @@ func audioTrackIndex(t *media.AudioTrack) int {
 	return t.Index
 }
 
+// stopSession detaches a viewer from its stream and stops the jobs it
+// started. When the last viewer leaves, ffmpeg is stopped for good; the
+// directory of a shared stream is kept for replays, any other is removed.
+func (s *Server) stopSession(sessionID string) {
+	s.streamMutex.Lock()
+	v, exists := s.viewers[sessionID]
+	last := false
+	if exists {
+		delete(s.viewers, sessionID)
+		v.stream.refs--
+		if last = v.stream.refs == 0; last {
+			delete(s.activeStreams, v.stream.id)
+		}
+	}
+	s.streamMutex.Unlock()
+	if !exists {
+		return
+	}
+	if v.limited {
+		s.limiter.leave(v.user)
+	}
+	stream := v.stream
+	if !last {
+		stream.stopViewer(sessionID)
+		log.Printf("INFO [server] viewer left stream session=%s viewer=%s", stream.id, sessionID)
+		return
+	}
+	stream.stop()
+	<-stream.ready
+	s.limiter.release(stream.slot)
+	log.Printf("INFO [server] stopped HLS stream session=%s", stream.id)
+	now := time.Now()
+	os.Chtimes(stream.dir, now, now)
+	if stream.key == "" {
+		go func() {
+			if err := os.RemoveAll(stream.dir); err != nil {
+				log.Printf("ERROR [server] failed to remove session dir=%s: %v", stream.dir, err)
+			}
+		}()
+	}
+}
+
+// requestViewer returns the viewer a request names by session id, nil when
+// there is none or it belongs to another user.
+func (s *Server) requestViewer(r *http.Request, sessionID string) *viewer {
+	s.streamMutex.Lock()
+	v := s.viewers[sessionID]
+	s.streamMutex.Unlock()
+	if v == nil || v.user != userFromRequest(r) {
+		return nil
+	}
+	return v
+}
+
 func (s *Server) HandleStreamStop(w http.ResponseWriter, r *http.Request) {
 	sessionID := r.URL.Query().Get("session")
-	if sessionID == "" {
+	if s.requestViewer(r, sessionID) == nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
 		return
 	}
+	s.stopSession(sessionID)
+	w.WriteHeader(200)
+}
 
-	s.streamMutex.Lock()
-	if cmd, exists := s.activeStreams[sessionID]; exists {
-		cmd.Process.Kill()
-		cmd.Wait()
-		delete(s.activeStreams, sessionID)
-		log.Printf("INFO [server] stopped HLS stream session=%s", sessionID)
+// HandleStreamHeartbeat keeps a session alive while the player is paused or
+// playing from its buffer. Sessions the reaper already stopped get a 404 so
+// the player knows to start a new one.
+func (s *Server) HandleStreamHeartbeat(w http.ResponseWriter, r *http.Request) {
+	v := s.requestViewer(r, r.URL.Query().Get("session"))
+	if v == nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
 	}
-	s.streamMutex.Unlock()
+	v.heartbeat()
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	go func() {
-		sessionDir := filepath.Join(s.config.CachePath, sessionID)
-		if err := os.RemoveAll(sessionDir); err != nil {
-			log.Printf("ERROR [server] failed to remove session dir=%s: %v", sessionDir, err)
+// shutdownStreams stops every stream when the server exits. ffmpeg gets until
+// the deadline to exit on its own before it is killed. Shared streams keep
+// their directories for the next run; the rest are removed.
+func (s *Server) shutdownStreams(deadline time.Duration) {
+	s.streamMutex.Lock()
+	streams, viewers := s.activeStreams, s.viewers
+	s.activeStreams = make(map[string]*streamSession)
+	s.viewers = make(map[string]*viewer)
+	s.streamMutex.Unlock()
+	for _, v := range viewers {
+		if v.limited {
+			s.limiter.leave(v.user)
+		}
+	}
+	if len(streams) == 0 {
+		return
+	}
+	log.Printf("INFO [server] stopping streams count=%d", len(streams))
+
+	var running []<-chan struct{}
+	for _, stream := range streams {
+		<-stream.ready
+		s.limiter.release(stream.slot)
+		for _, h := range stream.variants {
+			running = append(running, h.terminate()...)
 		}
-	}()
+	}
+	timeout := time.After(deadline)
+	for _, exited := range running {
+		select {
+		case <-exited:
+		case <-timeout:
+			// Past the deadline the remaining jobs are killed by stop.
+		}
+	}
+	for id, stream := range streams {
+		stream.stop()
+		if stream.key == "" {
+			if err := os.RemoveAll(stream.dir); err != nil {
+				log.Printf("ERROR [server] failed to remove session dir=%s: %v", stream.dir, err)
+			}
+		}
+		log.Printf("INFO [server] stopped HLS stream session=%s", id)
+	}
+}
 
-	w.WriteHeader(200)
+// reapIdleStreams stops sessions nobody has fetched from or heartbeated for
+// longer than the idle timeout, such as those of a closed browser tab.
+func (s *Server) reapIdleStreams(ctx context.Context) {
+	timeout := s.config.StreamIdleTimeout
+	if timeout <= 0 {
+		return
+	}
+	ticker := time.NewTicker(min(timeout/2, 30*time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		var idle []string
+		s.streamMutex.Lock()
+		for id, v := range s.viewers {
+			if v.idle() > timeout {
+				idle = append(idle, id)
+			}
+		}
+		s.streamMutex.Unlock()
+		for _, id := range idle {
+			log.Printf("INFO [server] reaping idle stream viewer=%s timeout=%s", id, timeout)
+			s.stopSession(id)
+		}
+	}
 }
 
 func (s *Server) makeHLSHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		rel := strings.TrimPrefix(r.URL.Path, "/")
-		rel = filepath.Clean(rel)
-		fullPath := filepath.Join(s.config.CachePath, rel)
-		if !strings.HasPrefix(fullPath, s.config.CachePath) {
+		// Paths start with the viewer's session id, which maps to the
+		// directory of the stream it watches.
+		rel := filepath.ToSlash(filepath.Clean(strings.TrimPrefix(r.URL.Path, "/")))
+		sessionID, name, ok := strings.Cut(rel, "/")
+		v := s.requestViewer(r, sessionID)
+		if !ok || v == nil {
+			http.NotFound(w, r)
+			return
+		}
+		v.heartbeat()
+		stream := v.stream
+		fullPath := filepath.Join(stream.dir, name)
+		if !strings.HasPrefix(fullPath, stream.dir+string(filepath.Separator)) {
 			log.Printf("INFO [server] HLS rejected traversal path=%s", fullPath)
 			http.NotFound(w, r)
 			return
 		}
+		// Segments of VOD sessions are produced when they are asked for.
+		if hls, file := stream.lookup(name); hls != nil && hls.vod() {
+			stream.touch(sessionID, hls)
+			var err error
+			if n, isSegment := hls.parseSegment(file); isSegment {
+				err = hls.waitSegment(sessionID, n, segmentWait)
+			} else if file == "init.mp4" && !hls.ts {
+				err = hls.waitInit(sessionID, segmentWait)
+			}
+			if errors.Is(err, errSegmentRange) {
+				http.NotFound(w, r)
+				return
+			}
+			if err != nil {
+				log.Printf("WARN [server] HLS segment unavailable session=%s file=%s: %v", stream.id, name, err)
+				http.Error(w, "Segment not available", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		// Subtitles are converted when first asked for.
+		var n int
+		var ext string
+		if _, err := fmt.Sscanf(strings.Replace(name, ".", " ", 1), "sub_%d %s", &n, &ext); err == nil && ext == "vtt" {
+			path, err := s.subtitleFile(stream, n)
+			if err != nil {
+				log.Printf("ERROR [server] failed to convert subtitle session=%s track=%d: %v", stream.id, n, err)
+				http.NotFound(w, r)
+				return
+			}
+			fullPath = path
+		}
 		if _, err := os.Stat(fullPath); err != nil {
 			log.Printf("DEBUG [server] HLS miss path=%s: %v", fullPath, err)
 			http.NotFound(w, r)
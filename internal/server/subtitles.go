@@ -0,0 +1,176 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/tanq16/raikiri/internal/auth"
+	"github.com/tanq16/raikiri/internal/media"
+)
+
+// subtitleVideo resolves the video a subtitle request is for, provided the
+// user holds perm on it, answering the request itself when it cannot be
+// served.
+func (s *Server) subtitleVideo(w http.ResponseWriter, r *http.Request, mode, targetFile, perm string) (string, bool) {
+	fullPath, ok := s.resolveWithinRoot(mode, targetFile)
+	if !ok || targetFile == "" {
+		http.Error(w, "Invalid path", 400)
+		return "", false
+	}
+	if !s.allowed(r, mode, targetFile, perm) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return "", false
+	}
+	if info, err := os.Stat(fullPath); err != nil || info.IsDir() {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return "", false
+	}
+	return fullPath, true
+}
+
+// HandleSubtitle reads and sets the timing offsets of the subtitles of a
+// video, kept per file for every viewer. Offsets are in seconds; positive
+// ones delay the subtitles, and zero clears one. As they change the file
+// for everyone, setting one takes the upload permission.
+//
+//	GET       ?mode=&file=               subtitle tracks with their offsets
+//	PUT       {mode, file, track, offset}  track is the index listed by /api/stream
+//
+// POST is accepted like PUT.
+func (s *Server) HandleSubtitle(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		fullPath, ok := s.subtitleVideo(w, r, r.URL.Query().Get("mode"), r.URL.Query().Get("file"), auth.PermRead)
+		if !ok {
+			return
+		}
+		subtitles, tracks := listSubtitles(fullPath)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.withOffsets(fullPath, subtitles, tracks))
+
+	case "PUT", "POST":
+		var req struct {
+			Mode   string  `json:"mode"`
+			File   string  `json:"file"`
+			Track  int     `json:"track"`
+			Offset float64 `json:"offset"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", 400)
+			return
+		}
+		fullPath, ok := s.subtitleVideo(w, r, req.Mode, req.File, auth.PermUpload)
+		if !ok {
+			return
+		}
+		subtitles, tracks := listSubtitles(fullPath)
+		if req.Track < 1 || req.Track > len(tracks) || tracks[req.Track-1].BurnIn {
+			http.Error(w, "No such subtitle track", http.StatusNotFound)
+			return
+		}
+		offset, err := s.subtitleSync.Set(fullPath, tracks[req.Track-1].ID(), req.Offset)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		log.Printf("INFO [server] subtitle offset set file=%s track=%d offset=%.3f", req.File, req.Track, offset)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.withOffsets(fullPath, subtitles, tracks))
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleSubtitleASS serves an ASS/SSA subtitle track as it is, styling and
+// all, for players that render it with libass rather than as WebVTT.
+//
+//	GET ?mode=&file=&track=   track is the index listed by /api/stream
+func (s *Server) HandleSubtitleASS(w http.ResponseWriter, r *http.Request) {
+	fullPath, ok := s.subtitleVideo(w, r, r.URL.Query().Get("mode"), r.URL.Query().Get("file"), auth.PermRead)
+	if !ok {
+		return
+	}
+	n, _ := strconv.Atoi(r.URL.Query().Get("track"))
+	_, tracks := listSubtitles(fullPath)
+	if n < 1 || n > len(tracks) || !tracks[n-1].Styled() {
+		http.Error(w, "No such ASS subtitle track", http.StatusNotFound)
+		return
+	}
+	path, err := media.CachedASS(fullPath, tracks[n-1])
+	if err != nil {
+		log.Printf("ERROR [server] failed to extract ASS subtitle file=%s track=%d: %v", r.URL.Query().Get("file"), n, err)
+		http.Error(w, "Could not extract subtitle", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/x-ssa; charset=utf-8")
+	http.ServeFile(w, r, path)
+}
+
+// subtitleFont is a font attached to a video, as listed to players.
+type subtitleFont struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// HandleSubtitleFonts lists the fonts attached to a video, which its ASS
+// subtitles are typeset with.
+//
+//	GET ?mode=&file=
+func (s *Server) HandleSubtitleFonts(w http.ResponseWriter, r *http.Request) {
+	fullPath, ok := s.subtitleVideo(w, r, r.URL.Query().Get("mode"), r.URL.Query().Get("file"), auth.PermRead)
+	if !ok {
+		return
+	}
+	fonts := []subtitleFont{}
+	dir, err := media.CachedFonts(fullPath)
+	if err != nil {
+		log.Printf("WARN [server] failed to extract fonts file=%s: %v", r.URL.Query().Get("file"), err)
+	}
+	if dir != "" {
+		entries, _ := os.ReadDir(dir)
+		for _, e := range entries {
+			if e.IsDir() {
+				continue
+			}
+			q := url.Values{}
+			q.Set("mode", r.URL.Query().Get("mode"))
+			q.Set("file", r.URL.Query().Get("file"))
+			q.Set("name", e.Name())
+			fonts = append(fonts, subtitleFont{Name: e.Name(), URL: "/api/subtitle/font?" + q.Encode()})
+		}
+	}
+	sort.Slice(fonts, func(i, j int) bool { return fonts[i].Name < fonts[j].Name })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fonts)
+}
+
+// HandleSubtitleFont serves one font attached to a video.
+//
+//	GET ?mode=&file=&name=
+func (s *Server) HandleSubtitleFont(w http.ResponseWriter, r *http.Request) {
+	fullPath, ok := s.subtitleVideo(w, r, r.URL.Query().Get("mode"), r.URL.Query().Get("file"), auth.PermRead)
+	if !ok {
+		return
+	}
+	dir, err := media.CachedFonts(fullPath)
+	name := filepath.Base(r.URL.Query().Get("name"))
+	if err != nil || dir == "" || name == "." || name == string(filepath.Separator) {
+		http.NotFound(w, r)
+		return
+	}
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	// Fonts of a given video version never change.
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	http.ServeFile(w, r, path)
+}
@@ -0,0 +1,67 @@
+// Package store holds small persistence helpers shared by the server state
+// kept in the data directory.
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// JSONFile persists a value as indented JSON and remembers the modification
+// time of the last read or write, so that edits made by another process (the
+// CLI against a running server) can be detected and reloaded.
+type JSONFile struct {
+	Path    string
+	modTime time.Time
+}
+
+// Changed reports whether the file differs from the last read or write.
+func (f *JSONFile) Changed() bool {
+	info, err := os.Stat(f.Path)
+	if os.IsNotExist(err) {
+		return !f.modTime.IsZero()
+	}
+	return err == nil && !info.ModTime().Equal(f.modTime)
+}
+
+// Read decodes the file into v. A missing file leaves v untouched.
+func (f *JSONFile) Read(v any) error {
+	info, err := os.Stat(f.Path)
+	if os.IsNotExist(err) {
+		f.modTime = time.Time{}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", f.Path, err)
+	}
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", f.Path, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", f.Path, err)
+	}
+	f.modTime = info.ModTime()
+	return nil
+}
+
+// Write atomically replaces the file with v encoded as JSON.
+func (f *JSONFile) Write(v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", f.Path, err)
+	}
+	tmp := f.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", f.Path, err)
+	}
+	if err := os.Rename(tmp, f.Path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", f.Path, err)
+	}
+	if info, err := os.Stat(f.Path); err == nil {
+		f.modTime = info.ModTime()
+	}
+	return nil
+}
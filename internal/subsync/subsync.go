@@ -0,0 +1,79 @@
+// Package subsync keeps the timing corrections set for subtitle tracks that
+// are out of sync with their video.
+package subsync
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/tanq16/raikiri/internal/store"
+)
+
+const offsetsFile = "subtitle_offsets.json"
+
+// MaxOffset bounds an offset in seconds either way; anything larger is a
+// different file rather than a sync problem.
+const MaxOffset = 600
+
+// Store keeps subtitle offsets per video and track in subtitle_offsets.json.
+// Offsets are in seconds, positive ones delaying the subtitles.
+type Store struct {
+	file  store.JSONFile
+	mu    sync.RWMutex
+	files map[string]map[string]float64 // video path -> track id -> offset
+}
+
+func Open(dataDir string) (*Store, error) {
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	s := &Store{
+		file:  store.JSONFile{Path: filepath.Join(dataDir, offsetsFile)},
+		files: make(map[string]map[string]float64),
+	}
+	if err := s.file.Read(&s.files); err != nil {
+		return nil, err
+	}
+	// A file holding null decodes to a nil map.
+	if s.files == nil {
+		s.files = make(map[string]map[string]float64)
+	}
+	return s, nil
+}
+
+// Offset returns the offset of a track, zero when none is set.
+func (s *Store) Offset(video, track string) float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.files[video][track]
+}
+
+// Set records the offset of a track, rounded to milliseconds; zero clears
+// it.
+func (s *Store) Set(video, track string, offset float64) (float64, error) {
+	if math.IsNaN(offset) || math.Abs(offset) > MaxOffset {
+		return 0, fmt.Errorf("offset must be within %d seconds", MaxOffset)
+	}
+	offset = math.Round(offset*1000) / 1000
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if offset == 0 {
+		if _, ok := s.files[video][track]; !ok {
+			return 0, nil
+		}
+		delete(s.files[video], track)
+		if len(s.files[video]) == 0 {
+			delete(s.files, video)
+		}
+		return 0, s.file.Write(s.files)
+	}
+	if s.files[video] == nil {
+		s.files[video] = make(map[string]float64)
+	}
+	s.files[video][track] = offset
+	return offset, s.file.Write(s.files)
+}
@@ -0,0 +1,29 @@
+package subsync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetAfterNullFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, offsetsFile), []byte("null"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.Set("/media/a.mkv", "stream:3", 1.2345); err != nil || got != 1.235 {
+		t.Fatalf("Set() = %v, %v, want 1.235", got, err)
+	}
+
+	s, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Offset("/media/a.mkv", "stream:3"); got != 1.235 {
+		t.Fatalf("Offset() = %v after reopening, want 1.235", got)
+	}
+}
@@ -48,7 +48,7 @@ type encodeResult struct {
 }
 
 func RunEncode(ctx context.Context, inputFile string, opts EncodeOptions) error {
-	data, err := getVideoInfo(inputFile)
+	data, err := ProbeFile(inputFile)
 	if err != nil {
 		return err
 	}
@@ -20,12 +20,14 @@ type Stream struct {
 	Index         int         `json:"index"`
 	CodecType     string      `json:"codec_type"`
 	CodecName     string      `json:"codec_name"`
+	Profile       string      `json:"profile,omitempty"`
 	Width         int         `json:"width,omitempty"`
 	Height        int         `json:"height,omitempty"`
 	BitRate       string      `json:"bit_rate,omitempty"`
 	AvgFrameRate  string      `json:"avg_frame_rate,omitempty"`
 	RFrameRate    string      `json:"r_frame_rate,omitempty"`
 	PixFmt        string      `json:"pix_fmt,omitempty"`
+	ColorTransfer string      `json:"color_transfer,omitempty"`
 	Channels      int         `json:"channels,omitempty"`
 	ChannelLayout string      `json:"channel_layout,omitempty"`
 	SampleRate    string      `json:"sample_rate,omitempty"`
@@ -34,12 +36,16 @@ type Stream struct {
 }
 
 type Disposition struct {
-	Comment        int `json:"comment"`
-	VisualImpaired int `json:"visual_impaired"`
+	Default         int `json:"default"`
+	Forced          int `json:"forced"`
+	HearingImpaired int `json:"hearing_impaired"`
+	Comment         int `json:"comment"`
+	VisualImpaired  int `json:"visual_impaired"`
 }
 
 type Format struct {
 	Filename   string `json:"filename"`
+	StartTime  string `json:"start_time,omitempty"`
 	Duration   string `json:"duration"`
 	Size       string `json:"size"`
 	BitRate    string `json:"bit_rate"`
@@ -50,10 +56,14 @@ type Tags struct {
 	Language string `json:"language,omitempty"`
 	Title    string `json:"title,omitempty"`
 	BPS      string `json:"BPS,omitempty"`
+	// Attachments, such as the fonts of styled subtitles, carry their file
+	// name and type.
+	Filename string `json:"filename,omitempty"`
+	Mimetype string `json:"mimetype,omitempty"`
 }
 
 func RunVideoInfo(inputFile string) error {
-	data, err := getVideoInfo(inputFile)
+	data, err := ProbeFile(inputFile)
 	if err != nil {
 		return err
 	}
@@ -63,7 +73,8 @@ func RunVideoInfo(inputFile string) error {
 	return nil
 }
 
-func getVideoInfo(inputFile string) (*FFProbeOutput, error) {
+// ProbeFile runs ffprobe once and returns the parsed format and stream details.
+func ProbeFile(inputFile string) (*FFProbeOutput, error) {
 	cmd := exec.Command("ffprobe",
 		"-v", "quiet",
 		"-print_format", "json",